@@ -0,0 +1,421 @@
+package abc
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// Model simulates the summaries of parameters, in the order of the priors,
+// with a seed, a positive 31-bit integer as simmlst accepts.
+type Model func(ctx context.Context, params []float64, seed int64) ([]float64, error)
+
+// Sampler samples the posterior of parameters given observed summaries.
+// Simulations run on parallel workers, but samples depend only on Seed.
+type Sampler struct {
+	Priors   []Prior
+	Model    Model
+	Distance Distance
+	Workers  int
+	Seed     int64
+
+	// Log, if not nil, is called with every failed simulation,
+	// which is rejected.
+	Log func(params []float64, err error)
+}
+
+// Particle is a sampled set of parameters.
+type Particle struct {
+	Params    []float64
+	Summaries []float64
+	Distance  float64
+	Weight    float64
+}
+
+// Population is the particles accepted within a tolerance.
+type Population struct {
+	Tolerance   float64
+	Simulations int // simulations run to fill the population.
+	Failed      int // failed simulations among them.
+	Particles   []Particle
+}
+
+// Schedule is the tolerances of the generations of ABC-SMC:
+// the given Tolerances, or else tolerances at the Quantile of the distances
+// of every previous generation, for Generations generations.
+// The first generation samples the priors, within the largest distance.
+type Schedule struct {
+	Tolerances     []float64
+	Quantile       float64
+	Generations    int
+	MaxSimulations int // of a generation, 0 for no limit.
+}
+
+// Validate checks the schedule.
+func (s Schedule) Validate() error {
+	if len(s.Tolerances) > 0 {
+		for i := 1; i < len(s.Tolerances); i++ {
+			if !(s.Tolerances[i] < s.Tolerances[i-1]) {
+				return fmt.Errorf("tolerances %v are not decreasing", s.Tolerances)
+			}
+		}
+		return nil
+	}
+	if !(s.Quantile > 0 && s.Quantile < 1) {
+		return fmt.Errorf("tolerance quantile %g, expect within (0, 1)", s.Quantile)
+	}
+	if s.Generations < 1 {
+		return fmt.Errorf("%d generations, expect at least 1", s.Generations)
+	}
+	return nil
+}
+
+func (s Schedule) generations() int {
+	if len(s.Tolerances) > 0 {
+		return len(s.Tolerances)
+	}
+	return s.Generations
+}
+
+type job struct {
+	params []float64
+	seed   int64
+}
+
+type outcome struct {
+	summaries []float64
+	err       error
+}
+
+// simulate runs jobs on the workers, and returns their outcomes in order.
+func (s *Sampler) simulate(ctx context.Context, jobs []job) []outcome {
+	outcomes := make([]outcome, len(jobs))
+	workers := s.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				sum, err := s.Model(ctx, jobs[i].params, jobs[i].seed)
+				outcomes[i] = outcome{sum, err}
+			}
+		}()
+	}
+	for i := range jobs {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	for i, o := range outcomes {
+		if o.err != nil && s.Log != nil {
+			s.Log(jobs[i].params, o.err)
+		}
+	}
+	return outcomes
+}
+
+// prior draws n jobs from the priors.
+func (s *Sampler) prior(r *rand.Rand, n int) []job {
+	jobs := make([]job, n)
+	for i := range jobs {
+		params := make([]float64, len(s.Priors))
+		for k, p := range s.Priors {
+			params[k] = p.Sample(r)
+		}
+		jobs[i] = job{params, newSeed(r)}
+	}
+	return jobs
+}
+
+// newSeed draws a positive 31-bit seed.
+func newSeed(r *rand.Rand) int64 {
+	return 1 + r.Int63n(math.MaxInt32)
+}
+
+// pilot simulates n draws from the priors, and returns them as particles
+// of equal weights, with the scales of their summaries.
+// Draws whose distance is NaN are left out of the particles.
+func (s *Sampler) pilot(ctx context.Context, r *rand.Rand, observed []float64, n int) (Population, []float64, error) {
+	jobs := s.prior(r, n)
+	outcomes := s.simulate(ctx, jobs)
+	if err := ctx.Err(); err != nil {
+		return Population{}, nil, err
+	}
+
+	pop := Population{Simulations: n}
+	var summaries [][]float64
+	var particles []Particle
+	for i, o := range outcomes {
+		if o.err != nil {
+			pop.Failed++
+			continue
+		}
+		summaries = append(summaries, o.summaries)
+		particles = append(particles, Particle{Params: jobs[i].params, Summaries: o.summaries})
+	}
+	if len(particles) == 0 {
+		return pop, nil, fmt.Errorf("abc: all %d simulations failed", n)
+	}
+
+	scales := Scales(summaries)
+	for _, p := range particles {
+		p.Distance = s.Distance(p.Summaries, observed, scales)
+		if !math.IsNaN(p.Distance) {
+			pop.Particles = append(pop.Particles, p)
+			pop.Tolerance = math.Max(pop.Tolerance, p.Distance)
+		}
+	}
+	if len(pop.Particles) == 0 {
+		return pop, nil, fmt.Errorf("abc: no simulations with a distance")
+	}
+	for i := range pop.Particles {
+		pop.Particles[i].Weight = 1 / float64(len(pop.Particles))
+	}
+	return pop, scales, nil
+}
+
+// Rejection simulates n draws from the priors, and accepts the fraction
+// closest to the observed summaries, of equal weights.
+// Draws whose distance is NaN are never accepted.
+func (s *Sampler) Rejection(ctx context.Context, observed []float64, n int, accept float64) (Population, error) {
+	r := rand.New(rand.NewSource(s.Seed))
+	pop, _, err := s.pilot(ctx, r, observed, n)
+	if err != nil {
+		return pop, err
+	}
+
+	particles := pop.Particles
+	sort.SliceStable(particles, func(i, j int) bool {
+		return particles[i].Distance < particles[j].Distance
+	})
+	k := int(math.Ceil(accept * float64(n)))
+	if k < 1 {
+		k = 1
+	}
+	if k > len(particles) {
+		k = len(particles)
+	}
+
+	pop.Particles = particles[:k]
+	pop.Tolerance = particles[k-1].Distance
+	for i := range pop.Particles {
+		pop.Particles[i].Weight = 1 / float64(k)
+	}
+	return pop, nil
+}
+
+// SMC samples the posterior by ABC-SMC (population Monte Carlo):
+// every generation perturbs particles drawn from the previous one,
+// with a Gaussian kernel of twice their weighted variance (rounded for
+// Integer priors), until n simulations fall within its tolerance,
+// and weighs them by importance. A generation whose first n simulations
+// all failed, or have no distance, stops the sampler with an error.
+// The populations of all generations are returned; the last is the posterior.
+func (s *Sampler) SMC(ctx context.Context, observed []float64, n int, schedule Schedule) ([]Population, error) {
+	if err := schedule.Validate(); err != nil {
+		return nil, err
+	}
+	r := rand.New(rand.NewSource(s.Seed))
+	pop, scales, err := s.pilot(ctx, r, observed, n)
+	if err != nil {
+		return nil, err
+	}
+	pops := []Population{pop}
+
+	for g := 0; g < schedule.generations(); g++ {
+		prev := pops[len(pops)-1]
+		var eps float64
+		if len(schedule.Tolerances) > 0 {
+			eps = schedule.Tolerances[g]
+		} else {
+			eps = distanceQuantile(prev.Particles, schedule.Quantile)
+		}
+
+		sigmas := kernelSigmas(prev.Particles, len(s.Priors))
+		cum := make([]float64, len(prev.Particles))
+		total := 0.0
+		for i, p := range prev.Particles {
+			total += p.Weight
+			cum[i] = total
+		}
+
+		next := Population{Tolerance: eps}
+		measured := 0 // simulations with a distance.
+		for len(next.Particles) < n {
+			if schedule.MaxSimulations > 0 && next.Simulations >= schedule.MaxSimulations {
+				return pops, fmt.Errorf("abc: %d of %d particles within tolerance %g after %d simulations",
+					len(next.Particles), n, eps, next.Simulations)
+			}
+
+			// propose a batch of parameters within the priors.
+			batch := n - len(next.Particles)
+			if schedule.MaxSimulations > 0 && next.Simulations+batch > schedule.MaxSimulations {
+				batch = schedule.MaxSimulations - next.Simulations
+			}
+			jobs := make([]job, batch)
+			for i := range jobs {
+				for {
+					k := sort.SearchFloat64s(cum, r.Float64()*total)
+					if k >= len(cum) {
+						k = len(cum) - 1
+					}
+					params := make([]float64, len(s.Priors))
+					for j := range params {
+						params[j] = s.Priors[j].round(prev.Particles[k].Params[j] + sigmas[j]*r.NormFloat64())
+					}
+					if density(s.Priors, params) > 0 {
+						jobs[i] = job{params, newSeed(r)}
+						break
+					}
+				}
+			}
+
+			outcomes := s.simulate(ctx, jobs)
+			if err := ctx.Err(); err != nil {
+				return pops, err
+			}
+			for i, o := range outcomes {
+				next.Simulations++
+				if o.err != nil {
+					next.Failed++
+					continue
+				}
+				d := s.Distance(o.summaries, observed, scales)
+				if !math.IsNaN(d) {
+					measured++
+				}
+				if d <= eps && len(next.Particles) < n {
+					next.Particles = append(next.Particles, Particle{Params: jobs[i].params, Summaries: o.summaries, Distance: d})
+				}
+			}
+			if measured == 0 && next.Simulations >= n {
+				return pops, fmt.Errorf("abc: none of %d simulations of generation %d has a distance, %d failed",
+					next.Simulations, g+1, next.Failed)
+			}
+		}
+
+		// importance weights: prior density over the density of the proposal.
+		sum := 0.0
+		for i := range next.Particles {
+			p := &next.Particles[i]
+			var q float64
+			for _, prevP := range prev.Particles {
+				k := prevP.Weight
+				for j := range p.Params {
+					k *= s.Priors[j].kernel(p.Params[j], prevP.Params[j], sigmas[j])
+				}
+				q += k
+			}
+			p.Weight = density(s.Priors, p.Params) / q
+			sum += p.Weight
+		}
+		for i := range next.Particles {
+			next.Particles[i].Weight /= sum
+		}
+		pops = append(pops, next)
+	}
+
+	return pops, nil
+}
+
+// kernelSigmas returns the standard deviations of the perturbation kernel,
+// square roots of twice the weighted variances of the parameters.
+func kernelSigmas(particles []Particle, dims int) []float64 {
+	sigmas := make([]float64, dims)
+	for j := range sigmas {
+		var mean, sum float64
+		for _, p := range particles {
+			mean += p.Weight * p.Params[j]
+			sum += p.Weight
+		}
+		mean /= sum
+		var v float64
+		for _, p := range particles {
+			d := p.Params[j] - mean
+			v += p.Weight * d * d
+		}
+		sigmas[j] = math.Sqrt(2 * v / sum)
+	}
+	return sigmas
+}
+
+// distanceQuantile returns the q quantile of the distances of particles.
+func distanceQuantile(particles []Particle, q float64) float64 {
+	var ds []float64
+	for _, p := range particles {
+		ds = append(ds, p.Distance)
+	}
+	sort.Float64s(ds)
+	return ds[int(q*float64(len(ds)-1))]
+}
+
+// normal returns the normal density of x, of mean mu and deviation sigma.
+func normal(x, mu, sigma float64) float64 {
+	if sigma == 0 {
+		if x == mu {
+			return 1
+		}
+		return 0
+	}
+	z := (x - mu) / sigma
+	return math.Exp(-z*z/2) / (sigma * math.Sqrt(2*math.Pi))
+}
+
+// Summary is the weighted posterior summary of a parameter.
+type Summary struct {
+	Name           string
+	Mean, SD       float64
+	Median, Lo, Hi float64 // quantiles 0.5, 0.025 and 0.975.
+}
+
+// Summarize returns the summaries of the parameters of a population,
+// and its effective sample size.
+func Summarize(priors []Prior, pop Population) ([]Summary, float64) {
+	var sw, sw2 float64
+	for _, p := range pop.Particles {
+		sw += p.Weight
+		sw2 += p.Weight * p.Weight
+	}
+
+	var summaries []Summary
+	for j, prior := range priors {
+		ps := make([]Particle, len(pop.Particles))
+		copy(ps, pop.Particles)
+		sort.SliceStable(ps, func(a, b int) bool { return ps[a].Params[j] < ps[b].Params[j] })
+
+		s := Summary{Name: prior.Name}
+		for _, p := range ps {
+			s.Mean += p.Weight * p.Params[j]
+		}
+		s.Mean /= sw
+		for _, p := range ps {
+			d := p.Params[j] - s.Mean
+			s.SD += p.Weight * d * d
+		}
+		s.SD = math.Sqrt(s.SD / sw)
+
+		quantile := func(q float64) float64 {
+			c := 0.0
+			for _, p := range ps {
+				c += p.Weight / sw
+				if c >= q {
+					return p.Params[j]
+				}
+			}
+			return ps[len(ps)-1].Params[j]
+		}
+		s.Median, s.Lo, s.Hi = quantile(0.5), quantile(0.025), quantile(0.975)
+		summaries = append(summaries, s)
+	}
+	return summaries, sw * sw / sw2
+}
@@ -0,0 +1,210 @@
+package abc
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+// gaussian simulates the means of 20 normal draws around the parameter.
+func gaussian(ctx context.Context, params []float64, seed int64) ([]float64, error) {
+	r := rand.New(rand.NewSource(seed))
+	var sum float64
+	for i := 0; i < 20; i++ {
+		sum += params[0] + r.NormFloat64()
+	}
+	return []float64{sum / 20}, nil
+}
+
+func newSampler(workers int) *Sampler {
+	return &Sampler{
+		Priors:   []Prior{{Name: "mu", Dist: "uniform", Min: -5, Max: 5}},
+		Model:    gaussian,
+		Distance: Euclidean,
+		Workers:  workers,
+		Seed:     1,
+	}
+}
+
+func TestRejection(t *testing.T) {
+	pop, err := newSampler(4).Rejection(context.Background(), []float64{1}, 4000, 0.05)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pop.Particles) != 200 {
+		t.Errorf("Expect 200 accepted particles, got %d", len(pop.Particles))
+	}
+	s, _ := Summarize(newSampler(1).Priors, pop)
+	// the posterior is about N(1, 1/20).
+	if math.Abs(s[0].Mean-1) > 0.15 || s[0].SD > 0.4 {
+		t.Errorf("Expect a posterior of mean 1 and sd 0.22, got %+v", s[0])
+	}
+}
+
+func TestSMC(t *testing.T) {
+	schedule := Schedule{Quantile: 0.5, Generations: 4, MaxSimulations: 100000}
+	pops, err := newSampler(4).SMC(context.Background(), []float64{1}, 300, schedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pops) != 5 {
+		t.Fatalf("Expect 5 populations, got %d", len(pops))
+	}
+	for g := 1; g < len(pops); g++ {
+		if !(pops[g].Tolerance < pops[g-1].Tolerance) {
+			t.Errorf("Expect decreasing tolerances, got %g after %g", pops[g].Tolerance, pops[g-1].Tolerance)
+		}
+	}
+	s, ess := Summarize(newSampler(1).Priors, pops[4])
+	if math.Abs(s[0].Mean-1) > 0.15 || s[0].SD > 0.4 {
+		t.Errorf("Expect a posterior of mean 1 and sd 0.22, got %+v", s[0])
+	}
+	if ess < 30 {
+		t.Errorf("Expect an effective sample size of at least 30, got %g", ess)
+	}
+
+	// samples do not depend on the number of workers.
+	pops1, err := newSampler(1).SMC(context.Background(), []float64{1}, 300, schedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pops, pops1) {
+		t.Errorf("Expect the same populations of 1 and 4 workers")
+	}
+}
+
+func TestSMCInteger(t *testing.T) {
+	s := newSampler(2)
+	s.Priors[0].Integer = true
+	schedule := Schedule{Quantile: 0.5, Generations: 2, MaxSimulations: 100000}
+	pops, err := s.SMC(context.Background(), []float64{1}, 200, schedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// particles hold the integers simulated.
+	for _, pop := range pops {
+		for _, p := range pop.Particles {
+			if x := p.Params[0]; x != math.Floor(x) {
+				t.Fatalf("Expect integer particles, got %g", x)
+			}
+		}
+	}
+}
+
+// TestSMCIntegerBound checks the posterior of an integer parameter
+// whose truth sits at the bound of its prior, where proposals fall out
+// of the prior, against the exact posterior within the final tolerance.
+func TestSMCIntegerBound(t *testing.T) {
+	s := &Sampler{
+		Priors: []Prior{{Name: "delta", Dist: "uniform", Min: 1, Max: 10, Integer: true}},
+		Model: func(ctx context.Context, params []float64, seed int64) ([]float64, error) {
+			r := rand.New(rand.NewSource(seed))
+			return []float64{params[0] + 2*r.NormFloat64()}, nil
+		},
+		Distance: Euclidean,
+		Workers:  4,
+		Seed:     1,
+	}
+	schedule := Schedule{Quantile: 0.5, Generations: 3, MaxSimulations: 100000}
+	pops, err := s.SMC(context.Background(), []float64{1}, 2000, schedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := pops[len(pops)-1]
+
+	// the tolerance in units of the summary.
+	var eps float64
+	for _, p := range last.Particles {
+		if p.Distance > 0 {
+			eps = last.Tolerance * math.Abs(p.Summaries[0]-1) / p.Distance
+			break
+		}
+	}
+	phi := func(x float64) float64 { return 0.5 * math.Erfc(-x/math.Sqrt2) }
+	var mean, sum float64
+	for k := 1.0; k <= 10; k++ {
+		w := phi((1+eps-k)/2) - phi((1-eps-k)/2)
+		mean += w * k
+		sum += w
+	}
+	mean /= sum
+
+	summaries, _ := Summarize(s.Priors, last)
+	if math.Abs(summaries[0].Mean-mean) > 0.2 {
+		t.Errorf("Expect a posterior mean of %.3g within tolerance %.3g, got %.3g", mean, eps, summaries[0].Mean)
+	}
+}
+
+// TestSMCFailing checks that a generation whose simulations all fail
+// stops the sampler, without a limit of simulations.
+func TestSMCFailing(t *testing.T) {
+	s := newSampler(2)
+	var calls int64
+	s.Model = func(ctx context.Context, params []float64, seed int64) ([]float64, error) {
+		// the pilot succeeds, the generations fail.
+		if atomic.AddInt64(&calls, 1) > 100 {
+			return nil, errors.New("failed")
+		}
+		return gaussian(ctx, params, seed)
+	}
+	pops, err := s.SMC(context.Background(), []float64{1}, 100, Schedule{Quantile: 0.5, Generations: 2})
+	if err == nil {
+		t.Fatalf("Expect an error of failed simulations")
+	}
+	if len(pops) != 1 {
+		t.Errorf("Expect the pilot population, got %d populations", len(pops))
+	}
+}
+
+func TestPrior(t *testing.T) {
+	p := Prior{Name: "rho", Dist: "loguniform", Min: 1, Max: 100}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(1))
+	below := 0
+	for i := 0; i < 10000; i++ {
+		if p.Sample(r) < 10 {
+			below++
+		}
+	}
+	if below < 4800 || below > 5200 {
+		t.Errorf("Expect half of log-uniform draws below 10, got %d of 10000", below)
+	}
+	if p.Density(0.5) != 0 || math.Abs(p.Density(10)-1/(10*math.Log(100))) > 1e-12 {
+		t.Errorf("Expect log-uniform densities, got %g and %g", p.Density(0.5), p.Density(10))
+	}
+	if err := (Prior{Name: "x", Dist: "loguniform", Min: 0, Max: 1}).Validate(); err == nil {
+		t.Errorf("Expect an error of a log-uniform prior from 0")
+	}
+
+	// integer priors draw integers within their bounds, of equal masses.
+	p = Prior{Name: "delta", Dist: "uniform", Min: 0.6, Max: 3.4, Integer: true}
+	counts := make(map[float64]int)
+	for i := 0; i < 30000; i++ {
+		x := p.Sample(r)
+		if x != math.Floor(x) || x < 1 || x > 3 {
+			t.Fatalf("Expect integers from 1 to 3, got %g", x)
+		}
+		counts[x]++
+	}
+	for k := 1.0; k <= 3; k++ {
+		if counts[k] < 9500 || counts[k] > 10500 {
+			t.Errorf("Expect a third of integer draws at %g, got %d of 30000", k, counts[k])
+		}
+		if math.Abs(p.Density(k)-1.0/3) > 1e-12 {
+			t.Errorf("Expect a probability of 1/3 at %g, got %g", k, p.Density(k))
+		}
+	}
+	if p.Density(1.5) != 0 || p.Density(0) != 0 || p.Density(4) != 0 {
+		t.Errorf("Expect no probability off the integers from 1 to 3, got %g, %g and %g",
+			p.Density(1.5), p.Density(0), p.Density(4))
+	}
+	if err := (Prior{Name: "x", Dist: "uniform", Min: 0.2, Max: 0.8, Integer: true}).Validate(); err == nil {
+		t.Errorf("Expect an error of an integer prior without integers")
+	}
+}
@@ -0,0 +1,105 @@
+package abc
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Distance measures how far simulated summaries x are from observed
+// summaries y, each summary divided by its scale.
+type Distance func(x, y, scale []float64) float64
+
+// Distances lists the distances by name.
+var Distances = map[string]Distance{
+	"euclidean": Euclidean,
+	"manhattan": Manhattan,
+	"chebyshev": Chebyshev,
+}
+
+// ParseDistance returns the distance of a name.
+func ParseDistance(name string) (Distance, error) {
+	d, found := Distances[name]
+	if !found {
+		return nil, fmt.Errorf("unknown distance %q, expect euclidean, manhattan or chebyshev", name)
+	}
+	return d, nil
+}
+
+// Euclidean is the square root of the sum of squared scaled differences.
+func Euclidean(x, y, scale []float64) float64 {
+	var d float64
+	for i := range x {
+		z := (x[i] - y[i]) / scale[i]
+		d += z * z
+	}
+	return math.Sqrt(d)
+}
+
+// Manhattan is the sum of absolute scaled differences.
+func Manhattan(x, y, scale []float64) float64 {
+	var d float64
+	for i := range x {
+		d += math.Abs(x[i]-y[i]) / scale[i]
+	}
+	return d
+}
+
+// Chebyshev is the largest absolute scaled difference.
+func Chebyshev(x, y, scale []float64) float64 {
+	var d float64
+	for i := range x {
+		d = math.Max(d, math.Abs(x[i]-y[i])/scale[i])
+	}
+	return d
+}
+
+// Scales returns the scale of every summary over simulations:
+// the median absolute deviation, or the standard deviation if that is zero,
+// or one if both are. Summaries of NaN are ignored.
+func Scales(summaries [][]float64) []float64 {
+	if len(summaries) == 0 {
+		return nil
+	}
+	scales := make([]float64, len(summaries[0]))
+	for k := range scales {
+		var vs []float64
+		for _, s := range summaries {
+			if !math.IsNaN(s[k]) {
+				vs = append(vs, s[k])
+			}
+		}
+		scales[k] = 1
+		if len(vs) < 2 {
+			continue
+		}
+
+		sort.Float64s(vs)
+		m := median(vs)
+		var devs []float64
+		var mean, ss float64
+		for _, v := range vs {
+			devs = append(devs, math.Abs(v-m))
+			mean += v
+		}
+		mean /= float64(len(vs))
+		for _, v := range vs {
+			ss += (v - mean) * (v - mean)
+		}
+		sort.Float64s(devs)
+		if mad := median(devs); mad > 0 {
+			scales[k] = mad
+		} else if sd := math.Sqrt(ss / float64(len(vs)-1)); sd > 0 {
+			scales[k] = sd
+		}
+	}
+	return scales
+}
+
+func median(sorted []float64) float64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
@@ -0,0 +1,128 @@
+// Package abc infers parameters of a simulator by approximate Bayesian
+// computation: parameters drawn from priors are accepted when the summaries
+// of their simulations are close enough to the observed summaries.
+package abc
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Prior is the prior distribution of a parameter,
+// uniform or log-uniform between Min and Max.
+// An Integer prior takes the integers k between Min and Max, of the
+// mass of the distribution over (k-1/2, k+1/2) between ceil(Min)-1/2
+// and floor(Max)+1/2, so that the integers at the bounds are not rarer.
+type Prior struct {
+	Name     string
+	Dist     string // uniform or loguniform.
+	Min, Max float64
+	Integer  bool
+}
+
+// Validate checks the distribution and its bounds.
+func (p Prior) Validate() error {
+	switch p.Dist {
+	case "uniform":
+		if !(p.Min < p.Max) {
+			return fmt.Errorf("prior of %s: uniform between %g and %g", p.Name, p.Min, p.Max)
+		}
+	case "loguniform":
+		if !(p.Min > 0 && p.Min < p.Max) {
+			return fmt.Errorf("prior of %s: log-uniform between %g and %g", p.Name, p.Min, p.Max)
+		}
+	default:
+		return fmt.Errorf("prior of %s: unknown distribution %q, expect uniform or loguniform", p.Name, p.Dist)
+	}
+	if p.Integer && math.Ceil(p.Min) > math.Floor(p.Max) {
+		return fmt.Errorf("prior of %s: no integer between %g and %g", p.Name, p.Min, p.Max)
+	}
+	return nil
+}
+
+// bounds returns the bounds of the continuous distribution.
+func (p Prior) bounds() (lo, hi float64) {
+	if p.Integer {
+		return math.Ceil(p.Min) - 0.5, math.Floor(p.Max) + 0.5
+	}
+	return p.Min, p.Max
+}
+
+// cdf returns the cumulative distribution of the continuous distribution.
+func (p Prior) cdf(x float64) float64 {
+	lo, hi := p.bounds()
+	x = math.Max(lo, math.Min(hi, x))
+	if p.Dist == "loguniform" {
+		return math.Log(x/lo) / math.Log(hi/lo)
+	}
+	return (x - lo) / (hi - lo)
+}
+
+// Sample draws a value from the prior.
+func (p Prior) Sample(r *rand.Rand) float64 {
+	lo, hi := p.bounds()
+	var x float64
+	if p.Dist == "loguniform" {
+		x = math.Exp(math.Log(lo) + r.Float64()*(math.Log(hi)-math.Log(lo)))
+	} else {
+		x = lo + r.Float64()*(hi-lo)
+	}
+	if p.Integer {
+		return math.Min(math.Floor(p.Max), p.round(x))
+	}
+	return x
+}
+
+// round rounds a value of an Integer prior to the nearest integer,
+// and returns other values unchanged.
+func (p Prior) round(x float64) float64 {
+	if !p.Integer {
+		return x
+	}
+	return math.Floor(x + 0.5)
+}
+
+// Density returns the prior density of a value,
+// or its probability if the prior is Integer.
+func (p Prior) Density(x float64) float64 {
+	if p.Integer {
+		if x != math.Floor(x) || x < math.Ceil(p.Min) || x > math.Floor(p.Max) {
+			return 0
+		}
+		return p.cdf(x+0.5) - p.cdf(x-0.5)
+	}
+	if x < p.Min || x > p.Max {
+		return 0
+	}
+	if p.Dist == "loguniform" {
+		return 1 / (x * (math.Log(p.Max) - math.Log(p.Min)))
+	}
+	return 1 / (p.Max - p.Min)
+}
+
+// kernel returns the density of the Gaussian kernel at x of mean mu
+// and deviation sigma, or, if the prior is Integer, the probability
+// that the kernel rounds to x.
+func (p Prior) kernel(x, mu, sigma float64) float64 {
+	if !p.Integer {
+		return normal(x, mu, sigma)
+	}
+	if sigma == 0 {
+		if p.round(mu) == x {
+			return 1
+		}
+		return 0
+	}
+	phi := func(y float64) float64 { return 0.5 * math.Erfc(-(y-mu)/(sigma*math.Sqrt2)) }
+	return phi(x+0.5) - phi(x-0.5)
+}
+
+// density returns the joint prior density of parameters.
+func density(priors []Prior, params []float64) float64 {
+	d := 1.0
+	for i, p := range priors {
+		d *= p.Density(params[i])
+	}
+	return d
+}
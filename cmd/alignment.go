@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"github.com/mingzhi/biogo/seq"
+	"github.com/mingzhi/simmlst/io"
+)
+
+// ReadAlignment reads an existing alignment (see io.ReadAlignment),
+// keeping blocks of at least two sequences, with their indices in the
+// file, so that they can be told apart. Blocks keep their order in the
+// file; blocks with XMFA headers are turned to the forward strand
+// of the first genome.
+func ReadAlignment(path string) ([][]*seq.Sequence, []int, error) {
+	geneGroups, err := io.ReadAlignment(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var kept [][]*seq.Sequence
+	var indices []int
+	for i, g := range geneGroups {
+		if len(g) < 2 {
+			continue
+		}
+		if b, err := io.ParseBlock(g); err == nil {
+			g = b.Oriented(1).Sequences()
+		}
+		kept = append(kept, g)
+		indices = append(indices, i)
+	}
+	return kept, indices, nil
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+)
+
+// Estimates are the recombination parameters of a parameter set,
+// mapped from the parameters of the models fitted to its Ct,
+// by default those of the fit of Coalescent to all lags:
+//
+//	rho/theta = Rho / Theta, the ratio of the per-site rates of gene
+//	            conversion and mutation,
+//	delta     = Delta, the mean length of tracts,
+//	phi       = rho/theta * delta.
+//
+// phi is a per-site replacement ratio: the rate at which a site is
+// replaced by tracts of gene conversion, relative to its mutation rate,
+// and not the ratio of the rates of recombination events and mutations,
+// which is rho/theta.
+// Each estimate is taken from the first fit whose model maps to it
+// (see Model), unless a fit to the same lags has a lower AIC.
+// Fits that did not converge are skipped, and listed in Undefined.
+// An estimate that cannot be mapped is nil, and listed in Undefined
+// with the reason.
+type Estimates struct {
+	RhoTheta  *float64
+	Delta     *float64
+	Phi       *float64
+	Undefined []string `json:",omitempty"`
+}
+
+// RhoTheta maps a fit of Hyper to rho/theta. At lags short before delta,
+// gene conversion separates two sites at the rate R = 2 rho l (see package
+// theory), and the covariance of Hudson is 1/(1 + 2R/3) to first order in R,
+// so that Ct(l) = theta^2 / (1 + 4 rho l / 3): B0 is theta^2, B1 is 4 rho / 3,
+// and rho/theta = 3 B1 / (4 sqrt(B0)).
+func RhoTheta(f FitResult) (float64, error) {
+	if !(f.B0 > 0) {
+		return 0, fmt.Errorf("B0 %g of %s is not positive", f.B0, f.Func)
+	}
+	rt := 0.75 * f.B1 / math.Sqrt(f.B0)
+	if !(rt >= 0) || math.IsInf(rt, 0) {
+		return 0, fmt.Errorf("B1 %g of %s gives rho/theta %g", f.B1, f.Func, rt)
+	}
+	return rt, nil
+}
+
+// TractLength maps a fit of Exp to delta. Tracts of geometric lengths
+// separate two sites at lag l at the rate R = 2 rho delta (1 - (1-1/delta)^l)
+// (see package theory), and to first order in R the covariance of Hudson
+// is 1 - 2R/3, so that Ct(l) = B0 + B1 exp(-l/B2), with B1 = 4 rho delta
+// theta^2 / 3 and B2 = -1/ln(1 - 1/delta), which is delta - 1/2 to within
+// 1/(12 delta): B2 is delta to within a site, while rho delta is small.
+// Beyond, Hudson's covariance saturates, and B2 falls short of delta.
+func TractLength(f FitResult) (float64, error) {
+	return length(f.B2, "B2", f.Func)
+}
+
+func length(v float64, name, fn string) (float64, error) {
+	if !(v > 0) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("%s %g of %s is not a positive length", name, v, fn)
+	}
+	return v, nil
+}
+
+// Estimate maps the fits of a parameter set to its estimates.
+func Estimate(fits []FitResult) Estimates {
+	var e Estimates
+	undefined := func(name string, err error) {
+		e.Undefined = append(e.Undefined, fmt.Sprintf("%s: %v", name, err))
+	}
+
+	// estimate returns the estimate of the chosen fit among those
+	// mapping to it, or else the reasons of those failing.
+	estimate := func(name string, mapping func(Model) func(FitResult) (float64, error)) *float64 {
+		var best *float64
+		var bestFit FitResult
+		var errs []error
+		skipped := false
+		for _, f := range fits {
+			m, err := LookupModel(f.Func)
+			if err != nil || mapping(m) == nil {
+				continue
+			}
+			if !f.Converged {
+				undefined(name, fmt.Errorf("fit of %s to lags %d to %d did not converge (%s)", f.Func, f.Start, f.End, f.Status))
+				skipped = true
+				continue
+			}
+			v, err := mapping(m)(f)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if best == nil || (comparable(f, bestFit) && f.AIC < bestFit.AIC) {
+				best, bestFit = &v, f
+			}
+		}
+		if best == nil {
+			if len(errs) == 0 && !skipped {
+				errs = append(errs, fmt.Errorf("no fit of a model mapping to it"))
+			}
+			for _, err := range errs {
+				undefined(name, err)
+			}
+		}
+		return best
+	}
+
+	e.RhoTheta = estimate("rho/theta", func(m Model) func(FitResult) (float64, error) { return m.RhoTheta })
+	e.Delta = estimate("delta", func(m Model) func(FitResult) (float64, error) { return m.Delta })
+	if e.RhoTheta != nil && e.Delta != nil {
+		phi := *e.RhoTheta * *e.Delta
+		e.Phi = &phi
+	} else {
+		undefined("phi", fmt.Errorf("needs rho/theta and delta"))
+	}
+	return e
+}
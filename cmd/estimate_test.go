@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"github.com/mingzhi/simmlst/theory"
+	"math"
+	"testing"
+)
+
+func TestEstimate(t *testing.T) {
+	fits := []FitResult{
+		{Func: "Exp", B0: 0.001, B1: 0.01, B2: 50, Converged: true},
+		{Func: "Hyper", B0: 0.04, B1: 0.4 / 3, Converged: true},
+	}
+	e := Estimate(fits)
+	if e.RhoTheta == nil || math.Abs(*e.RhoTheta-0.5) > 1e-12 {
+		t.Errorf("Expect rho/theta 0.5, got %v", e.RhoTheta)
+	}
+	if e.Delta == nil || *e.Delta != 50 {
+		t.Errorf("Expect delta 50, got %v", e.Delta)
+	}
+	if e.Phi == nil || math.Abs(*e.Phi-25) > 1e-10 {
+		t.Errorf("Expect phi 25, got %v", e.Phi)
+	}
+	if len(e.Undefined) != 0 {
+		t.Errorf("Expect every estimate defined, got %v", e.Undefined)
+	}
+
+	fits[0].B2 = -3
+	fits[1].B0 = 0
+	e = Estimate(fits)
+	if e.RhoTheta != nil || e.Delta != nil || e.Phi != nil {
+		t.Errorf("Expect undefined estimates, got %+v", e)
+	}
+	if len(e.Undefined) != 3 {
+		t.Errorf("Expect reasons of 3 undefined estimates, got %v", e.Undefined)
+	}
+
+	fits[0].B2 = 50
+	fits[0].Converged = false
+	e = Estimate(fits)
+	if e.Delta != nil || len(e.Undefined) != 3 {
+		t.Errorf("Expect delta undefined by a fit that did not converge, got %+v", e)
+	}
+
+	e = Estimate(fits[:1])
+	if e.RhoTheta != nil || len(e.Undefined) != 3 {
+		t.Errorf("Expect rho/theta undefined without a fit of Hyper, got %+v", e)
+	}
+}
+
+func TestRhoTheta(t *testing.T) {
+	p := theory.Params{N: 10, Theta: 0.05, Rho: 0.01, Delta: 100, Blocks: []int{1000}}
+	var c CovResult
+	for l := 0; l < 10; l++ {
+		c.Ct = append(c.Ct, p.Cov(l))
+	}
+	m, _ := LookupModel("Hyper")
+	f := m.Fit(c, 1, 10)
+	rt, err := m.RhoTheta(f)
+	// theta is fitted as the substitution rate of pairs, and the mapping
+	// holds to first order in the lag.
+	expected := p.Rho / p.Ks()
+	if err != nil || math.Abs(rt-expected) > 0.05*expected {
+		t.Errorf("Expect rho/theta %g, got %g (%v)", expected, rt, err)
+	}
+}
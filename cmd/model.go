@@ -0,0 +1,305 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/mingzhi/meta/fit"
+	"github.com/mingzhi/simmlst/nls"
+	"github.com/mingzhi/simmlst/theory"
+	"math"
+	"sort"
+)
+
+// Param is a parameter of a model, within bounds.
+type Param struct {
+	Name     string
+	Min, Max float64
+}
+
+// Model is a named curve of Ct(l), fitted by weighted least squares
+// from the start values of Start. RhoTheta and Delta map a fit
+// to estimates (see Estimates), if the model has them.
+type Model struct {
+	Name   string
+	Params []Param
+	Func   nls.Func
+	Start  func(x, y []float64) []float64
+
+	RhoTheta, Delta func(f FitResult) (float64, error)
+}
+
+var models = make(map[string]Model)
+
+// RegisterModel adds a model to the registry, or replaces the model of its name.
+func RegisterModel(m Model) {
+	models[m.Name] = m
+}
+
+// LookupModel returns the registered model of a name.
+func LookupModel(name string) (Model, error) {
+	m, found := models[name]
+	if !found {
+		return m, fmt.Errorf("unknown model %q, expect one of %v", name, ModelNames())
+	}
+	return m, nil
+}
+
+// ModelNames returns the names of registered models, sorted.
+func ModelNames() []string {
+	var names []string
+	for name := range models {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WithBounds returns a copy of the model with the bounds of some parameters
+// replaced, by their names.
+func (m Model) WithBounds(bounds map[string][2]float64) (Model, error) {
+	params := make([]Param, len(m.Params))
+	copy(params, m.Params)
+	for name, b := range bounds {
+		found := false
+		for i := range params {
+			if params[i].Name == name {
+				params[i].Min, params[i].Max = b[0], b[1]
+				found = true
+			}
+		}
+		if !found {
+			return m, fmt.Errorf("model %s has no parameter %q", m.Name, name)
+		}
+		if !(b[0] < b[1]) {
+			return m, fmt.Errorf("bounds %v of %s of model %s", b, name, m.Name)
+		}
+	}
+	m.Params = params
+	return m, nil
+}
+
+// Fit fits the model to Ct at lags from start to end, by least squares
+// weighted by the inverse variances of the means of Ct, if averaged
+// over replicates, or else unweighted.
+func (m Model) Fit(c CovResult, start, end int) FitResult {
+	fitRes := FitResult{Func: m.Name, Start: start, End: end}
+	var xdata, ydata, weights []float64
+	weighted := true
+	for i := start; i < end; i++ {
+		v := c.Ct[i]
+		if !math.IsNaN(v) {
+			xdata = append(xdata, float64(i))
+			ydata = append(ydata, v)
+			if i < len(c.CtVar) && c.CtVar[i] > 0 && c.CtN[i] > 0 {
+				weights = append(weights, float64(c.CtN[i])/c.CtVar[i])
+			} else {
+				weighted = false
+			}
+		}
+	}
+	if !weighted {
+		weights = nil
+	}
+
+	var opts nls.Options
+	for _, p := range m.Params {
+		fitRes.ParamNames = append(fitRes.ParamNames, p.Name)
+		opts.Lower = append(opts.Lower, p.Min)
+		opts.Upper = append(opts.Upper, p.Max)
+	}
+	par := make([]float64, len(m.Params))
+	if len(xdata) > 0 {
+		copy(par, m.Start(xdata, ydata))
+	}
+	ls := nls.Fit(m.Func, xdata, ydata, weights, par, opts)
+
+	fitRes.Params = ls.Params
+	fitRes.Cov = ls.Cov
+	fitRes.SE = ls.SE
+	fitRes.RSS = ls.RSS
+	fitRes.DOF = ls.DOF
+	fitRes.RedChiSq = ls.RedChiSq
+	fitRes.Weighted = ls.Weighted
+	fitRes.Converged = ls.Converged
+	fitRes.Status = ls.Status
+	fitRes.AIC, fitRes.BIC = criteria(ls, len(xdata))
+	if len(par) > 0 {
+		fitRes.B0 = ls.Params[0]
+	}
+	if len(par) > 1 {
+		fitRes.B1 = ls.Params[1]
+	}
+	if len(par) > 2 {
+		fitRes.B2 = ls.Params[2]
+	}
+	return fitRes
+}
+
+// criteria returns the AIC and BIC of a fit to n points, up to constants
+// shared by fits to the same points: the chi-square of a weighted fit,
+// or n log(RSS/n) of an unweighted fit, penalized by the parameters.
+// A fit of undefined chi-square has infinite criteria, so that it is
+// never preferred.
+func criteria(ls nls.Result, n int) (aic, bic float64) {
+	k := float64(len(ls.Params))
+	chi := ls.RSS
+	if !ls.Weighted {
+		chi = float64(n) * math.Log(ls.RSS/float64(n))
+	}
+	if math.IsNaN(chi) || math.IsInf(chi, 0) {
+		return math.Inf(1), math.Inf(1)
+	}
+	return chi + 2*k, chi + k*math.Log(float64(n))
+}
+
+// Compare compares fits of a parameter set by AIC and BIC,
+// among fits of the same lags, which are the only ones comparable.
+// Fits that did not converge, or of infinite criteria, are left out:
+// they have infinite differences and no weight.
+func Compare(fits []FitResult) {
+	for i := range fits {
+		if !fits[i].Converged {
+			fits[i].DeltaAIC, fits[i].DeltaBIC = math.Inf(1), math.Inf(1)
+			continue
+		}
+		minAIC, minBIC := fits[i].AIC, fits[i].BIC
+		for _, f := range fits {
+			if f.Converged && comparable(f, fits[i]) {
+				minAIC = math.Min(minAIC, f.AIC)
+				minBIC = math.Min(minBIC, f.BIC)
+			}
+		}
+		fits[i].DeltaAIC = difference(fits[i].AIC, minAIC)
+		fits[i].DeltaBIC = difference(fits[i].BIC, minBIC)
+	}
+
+	// Akaike weights.
+	for i := range fits {
+		var sum float64
+		for _, f := range fits {
+			if f.Converged && comparable(f, fits[i]) {
+				sum += math.Exp(-f.DeltaAIC / 2)
+			}
+		}
+		fits[i].AICWeight = 0
+		if fits[i].Converged && sum > 0 {
+			fits[i].AICWeight = math.Exp(-fits[i].DeltaAIC/2) / sum
+		}
+	}
+}
+
+// difference returns the difference of a criterion from the lowest,
+// infinite if the criterion is.
+func difference(v, min float64) float64 {
+	if math.IsInf(v, 1) {
+		return v
+	}
+	return v - min
+}
+
+func comparable(a, b FitResult) bool {
+	return a.Start == b.Start && a.End == b.End && a.Weighted == b.Weighted
+}
+
+// startExp starts B0 + B1 exp(-l/B2) from the fit of meta,
+// or else from a decay to zero.
+func startExp(x, y []float64) []float64 {
+	if p := fit.FitExp(x, y); len(p) >= 3 {
+		return p
+	}
+	y0, length := startDecay(x, y)
+	return []float64{0, y0, length}
+}
+
+// startDecay starts a decay from the first value,
+// and the lag where it falls below 1/e of it.
+func startDecay(x, y []float64) (y0, length float64) {
+	y0 = y[0]
+	length = x[len(x)-1]
+	for i := range y {
+		if math.Abs(y[i]) < math.Abs(y0)/math.E {
+			length = x[i]
+			break
+		}
+	}
+	return
+}
+
+// Decay lengths are bounded below by a site, so that exp(-l/B)
+// is defined at every lag.
+func init() {
+	inf := math.Inf(1)
+	RegisterModel(Model{
+		Name: "Exp",
+		// Ct(l) = B0 + B1 exp(-l/B2), an exponential plus a constant.
+		Params: []Param{{"B0", -inf, inf}, {"B1", -inf, inf}, {"B2", 1, inf}},
+		Func: func(l float64, b []float64) float64 {
+			return b[0] + b[1]*math.Exp(-l/b[2])
+		},
+		Start: startExp,
+		Delta: TractLength,
+	})
+	RegisterModel(Model{
+		Name: "PureExp",
+		// Ct(l) = B0 exp(-l/B1).
+		Params: []Param{{"B0", -inf, inf}, {"B1", 1, inf}},
+		Func: func(l float64, b []float64) float64 {
+			return b[0] * math.Exp(-l/b[1])
+		},
+		Start: func(x, y []float64) []float64 {
+			y0, length := startDecay(x, y)
+			return []float64{y0, length}
+		},
+		Delta: func(f FitResult) (float64, error) {
+			return length(f.B1, "B1", f.Func)
+		},
+	})
+	RegisterModel(Model{
+		Name: "DoubleExp",
+		// Ct(l) = B0 + B1 exp(-l/B2) + B3 exp(-l/B4).
+		Params: []Param{{"B0", -inf, inf}, {"B1", -inf, inf}, {"B2", 1, inf}, {"B3", -inf, inf}, {"B4", 1, inf}},
+		Func: func(l float64, b []float64) float64 {
+			return b[0] + b[1]*math.Exp(-l/b[2]) + b[3]*math.Exp(-l/b[4])
+		},
+		Start: func(x, y []float64) []float64 {
+			p := startExp(x, y)
+			return []float64{p[0], p[1] / 2, p[2] / 2, p[1] / 2, p[2] * 2}
+		},
+	})
+	RegisterModel(Model{
+		Name: "Hyper",
+		// Ct(l) = B0 / (1 + B1 l).
+		Params: []Param{{"B0", -inf, inf}, {"B1", 0, inf}},
+		Func: func(l float64, b []float64) float64 {
+			return b[0] / (1 + b[1]*l)
+		},
+		Start:    fit.FitHyper,
+		RhoTheta: RhoTheta,
+	})
+	RegisterModel(Model{
+		Name: "Coalescent",
+		// Ct(l) = theta^2 (r+18) / (r^2+13r+18), r = 2 rho delta (1 - (1-1/delta)^l):
+		// the covariance of the coalescence times of a pair of sequences
+		// at two sites (Hudson 1983), scaled by the squared mutation rate,
+		// where sites are separated by gene conversion at rate r
+		// (see package theory). Rates are per site, and Theta is fitted
+		// as the substitution rate of pairs of sequences.
+		Params: []Param{{"Theta", 0, inf}, {"Rho", 0, inf}, {"Delta", 1, inf}},
+		Func: func(l float64, b []float64) float64 {
+			return b[0] * b[0] * theory.Hudson(theory.Separation(b[1], b[2], l))
+		},
+		Start: func(x, y []float64) []float64 {
+			y0, length := startDecay(x, y)
+			theta := math.Sqrt(math.Abs(y0))
+			return []float64{theta, theta, length}
+		},
+		RhoTheta: func(f FitResult) (float64, error) {
+			if !(f.B0 > 0) {
+				return 0, fmt.Errorf("Theta %g of %s is not positive", f.B0, f.Func)
+			}
+			return f.B1 / f.B0, nil
+		},
+		Delta: func(f FitResult) (float64, error) {
+			return length(f.B2, "Delta", f.Func)
+		},
+	})
+}
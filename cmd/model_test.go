@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"encoding/json"
+	"github.com/mingzhi/simmlst/nls"
+	"math"
+	"testing"
+)
+
+func TestModelFit(t *testing.T) {
+	m, err := LookupModel("Coalescent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	truth := []float64{0.01, 0.02, 30}
+	var c CovResult
+	for l := 0; l < 200; l++ {
+		c.Ct = append(c.Ct, m.Func(float64(l), truth))
+	}
+
+	f := m.Fit(c, 1, 200)
+	if !f.Converged {
+		t.Fatalf("Expect a converged fit, got %+v", f)
+	}
+	for i, v := range truth {
+		if math.Abs(f.Params[i]-v) > 1e-3*v {
+			t.Errorf("Expect %s %g, got %g", f.ParamNames[i], v, f.Params[i])
+		}
+	}
+	rt, err := m.RhoTheta(f)
+	if err != nil || math.Abs(rt-2) > 1e-2 {
+		t.Errorf("Expect rho/theta 2, got %g (%v)", rt, err)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	fits := []FitResult{
+		{Func: "Exp", Start: 1, End: 100, AIC: 10, BIC: 20, Converged: true},
+		{Func: "Hyper", Start: 1, End: 100, AIC: 14, BIC: 18, Converged: true},
+		{Func: "Hyper", Start: 1, End: 10, AIC: 3, BIC: 4, Converged: true},
+	}
+	Compare(fits)
+	if fits[0].DeltaAIC != 0 || fits[1].DeltaAIC != 4 || fits[0].DeltaBIC != 2 || fits[1].DeltaBIC != 0 {
+		t.Errorf("Expect differences of AIC 0 and 4, and of BIC 2 and 0, got %+v", fits[:2])
+	}
+	w := 1 / (1 + math.Exp(-2))
+	if math.Abs(fits[0].AICWeight-w) > 1e-12 || math.Abs(fits[0].AICWeight+fits[1].AICWeight-1) > 1e-12 {
+		t.Errorf("Expect Akaike weight %g, got %g", w, fits[0].AICWeight)
+	}
+	// fits of other lags are not compared.
+	if fits[2].DeltaAIC != 0 || fits[2].AICWeight != 1 {
+		t.Errorf("Expect a fit of other lags on its own, got %+v", fits[2])
+	}
+}
+
+func TestCompareConverged(t *testing.T) {
+	fits := []FitResult{
+		{Func: "Exp", Start: 1, End: 100, AIC: 10, BIC: 20, Converged: true},
+		{Func: "Hyper", Start: 1, End: 100, AIC: 4, BIC: 8},
+	}
+	Compare(fits)
+	if fits[0].DeltaAIC != 0 || fits[0].DeltaBIC != 0 || fits[0].AICWeight != 1 {
+		t.Errorf("Expect the converged fit preferred, got %+v", fits[0])
+	}
+	if !math.IsInf(fits[1].DeltaAIC, 1) || fits[1].AICWeight != 0 {
+		t.Errorf("Expect a fit that did not converge left out, got %+v", fits[1])
+	}
+}
+
+func TestCompareInfinite(t *testing.T) {
+	aic, bic := criteria(nls.Result{RSS: math.NaN(), Weighted: true}, 10)
+	if !math.IsInf(aic, 1) || !math.IsInf(bic, 1) {
+		t.Fatalf("Expect infinite criteria of an undefined chi-square, got %g and %g", aic, bic)
+	}
+	fits := []FitResult{
+		{Func: "Exp", Start: 1, End: 100, AIC: 10, BIC: 20, Converged: true},
+		{Func: "Hyper", Start: 1, End: 100, AIC: aic, BIC: bic, Converged: true},
+		{Func: "Hyper", Start: 1, End: 10, AIC: aic, BIC: bic, Converged: true},
+	}
+	Compare(fits)
+	if fits[0].DeltaAIC != 0 || fits[0].AICWeight != 1 {
+		t.Errorf("Expect the finite fit preferred, got %+v", fits[0])
+	}
+	for _, f := range fits[1:] {
+		if !math.IsInf(f.DeltaAIC, 1) || f.AICWeight != 0 {
+			t.Errorf("Expect an infinite difference and no weight, got %g and %g", f.DeltaAIC, f.AICWeight)
+		}
+	}
+
+	data, err := json.Marshal(fits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []FitResult
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded[0].AIC != 10 || decoded[0].Func != "Exp" || !math.IsInf(decoded[1].AIC, 1) || !math.IsInf(decoded[1].DeltaBIC, 1) {
+		t.Errorf("Expect criteria to round-trip through JSON, got %+v", decoded[:2])
+	}
+}
+
+func TestWithBounds(t *testing.T) {
+	m, _ := LookupModel("Exp")
+	b, err := m.WithBounds(map[string][2]float64{"B2": {5, 50}})
+	if err != nil || b.Params[2].Min != 5 || b.Params[2].Max != 50 {
+		t.Errorf("Expect bounds 5 and 50 of B2, got %+v (%v)", b.Params, err)
+	}
+	if m.Params[2].Min != 1 {
+		t.Errorf("Expect the registered model unchanged, got %+v", m.Params)
+	}
+	if _, err := m.WithBounds(map[string][2]float64{"B9": {0, 1}}); err == nil {
+		t.Errorf("Expect an error of an unknown parameter")
+	}
+}
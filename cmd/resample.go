@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/mingzhi/simmlst/sites"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Resampling methods.
+const (
+	Bootstrap = "bootstrap" // units drawn with replacement.
+	Jackknife = "jackknife" // leaving out one unit at a time.
+)
+
+// Resampler resamples the units of a result, which are its genes,
+// or contiguous windows of its alignment blocks, to give confidence bands.
+type Resampler struct {
+	Method string
+	Reps   int     // bootstrap replicates.
+	Level  float64 // confidence level, such as 0.95.
+	Seed   int64   // seed of the bootstrap.
+}
+
+// Validate checks the method and the level.
+func (rs Resampler) Validate() error {
+	if rs.Method != Bootstrap && rs.Method != Jackknife {
+		return fmt.Errorf("unknown resampling method %q, expect %s or %s", rs.Method, Bootstrap, Jackknife)
+	}
+	if rs.Method == Bootstrap && rs.Reps < 2 {
+		return fmt.Errorf("%d bootstrap replicates, expect at least 2", rs.Reps)
+	}
+	if !(rs.Level > 0 && rs.Level < 1) {
+		return fmt.Errorf("confidence level %g, expect within (0, 1)", rs.Level)
+	}
+	return nil
+}
+
+// Band is a confidence band.
+type Band struct {
+	Lo, Hi float64
+}
+
+// Resampling is the confidence bands of a result,
+// and the results of the resampled alignments they come from.
+type Resampling struct {
+	Method     string
+	Level      float64
+	Units      int // resampled units.
+	Ks         *Band
+	Ct, Cm     []*Band
+	Replicates []CovResult
+}
+
+// Replicates returns the statistics of the resampled units.
+func (rs Resampler) Replicates(units []*sites.Stats) []*sites.Stats {
+	if len(units) == 0 {
+		return nil
+	}
+	total := sites.NewStats(units[0].MaxL, units[0].Circular)
+	for _, u := range units {
+		total.Append(u)
+	}
+
+	var reps []*sites.Stats
+	switch rs.Method {
+	case Bootstrap:
+		r := rand.New(rand.NewSource(rs.Seed))
+		counts := make([]int, len(units))
+		for k := 0; k < rs.Reps; k++ {
+			for i := range counts {
+				counts[i] = 0
+			}
+			for range units {
+				counts[r.Intn(len(units))]++
+			}
+			s := sites.NewStats(total.MaxL, total.Circular)
+			for i, u := range units {
+				if counts[i] > 0 {
+					s.AppendN(u, counts[i])
+				}
+			}
+			reps = append(reps, s)
+		}
+	case Jackknife:
+		for _, u := range units {
+			s := sites.NewStats(total.MaxL, total.Circular)
+			s.Append(total)
+			s.Remove(u)
+			reps = append(reps, s)
+		}
+	}
+	return reps
+}
+
+// Resample returns the confidence bands of the estimate c of units.
+func (rs Resampler) Resample(c CovResult, units []*sites.Stats, maxl int) *Resampling {
+	r := &Resampling{Method: rs.Method, Level: rs.Level, Units: len(units)}
+	for _, s := range rs.Replicates(units) {
+		r.Replicates = append(r.Replicates, NewCovResult(s, maxl))
+	}
+
+	var ks []float64
+	for _, rep := range r.Replicates {
+		ks = append(ks, rep.Ks)
+	}
+	r.Ks = rs.Band(c.Ks, ks)
+	lagBands := func(est []float64, get func(CovResult) []float64) []*Band {
+		var bands []*Band
+		for l, v := range est {
+			var vs []float64
+			for _, rep := range r.Replicates {
+				if x := get(rep); l < len(x) {
+					vs = append(vs, x[l])
+				}
+			}
+			bands = append(bands, rs.Band(v, vs))
+		}
+		return bands
+	}
+	r.Ct = lagBands(c.Ct, func(c CovResult) []float64 { return c.Ct })
+	r.Cm = lagBands(c.Cm, func(c CovResult) []float64 { return c.Cm })
+	return r
+}
+
+// Band returns the confidence band of an estimate from its replicates:
+// percentiles of bootstrap replicates, or the normal band
+// of the jackknife standard error. Replicates of NaN are ignored,
+// and the band is nil if fewer than two are left, or if the estimate
+// is undefined, NaN or infinite.
+func (rs Resampler) Band(estimate float64, replicates []float64) *Band {
+	if math.IsNaN(estimate) || math.IsInf(estimate, 0) {
+		return nil
+	}
+	var vs []float64
+	for _, v := range replicates {
+		if !math.IsNaN(v) {
+			vs = append(vs, v)
+		}
+	}
+	n := len(vs)
+	if n < 2 {
+		return nil
+	}
+
+	alpha := (1 - rs.Level) / 2
+	if rs.Method == Bootstrap {
+		sort.Float64s(vs)
+		return &Band{quantile(vs, alpha), quantile(vs, 1-alpha)}
+	}
+
+	var mean, ss float64
+	for _, v := range vs {
+		mean += v
+	}
+	mean /= float64(n)
+	for _, v := range vs {
+		ss += (v - mean) * (v - mean)
+	}
+	se := math.Sqrt(float64(n-1) / float64(n) * ss)
+	z := math.Sqrt2 * math.Erfinv(rs.Level)
+	return &Band{estimate - z*se, estimate + z*se}
+}
+
+// quantile returns the p quantile of sorted values, interpolated linearly.
+func quantile(sorted []float64, p float64) float64 {
+	h := p * float64(len(sorted)-1)
+	i := int(h)
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[i] + (h-float64(i))*(sorted[i+1]-sorted[i])
+}
+
+// Windows splits aligned sequences into contiguous windows of w columns,
+// the last of which may be shorter.
+func Windows(sequences [][]byte, w int) [][][]byte {
+	if len(sequences) == 0 {
+		return nil
+	}
+	var windows [][][]byte
+	for start := 0; start < len(sequences[0]); start += w {
+		end := start + w
+		if end > len(sequences[0]) {
+			end = len(sequences[0])
+		}
+		var window [][]byte
+		for _, s := range sequences {
+			window = append(window, s[start:end])
+		}
+		windows = append(windows, window)
+	}
+	return windows
+}
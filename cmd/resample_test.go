@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"github.com/mingzhi/simmlst/cov"
+	"github.com/mingzhi/simmlst/sites"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func randomUnits(r *rand.Rand, n int) []*sites.Stats {
+	var units []*sites.Stats
+	for k := 0; k < n; k++ {
+		var sequences [][]byte
+		for i := 0; i < 5; i++ {
+			s := make([]byte, 80)
+			for j := range s {
+				s[j] = "AAACGT-"[r.Intn(7)]
+			}
+			sequences = append(sequences, s)
+		}
+		units = append(units, sites.Calc(sites.NewTable(sequences, cov.SkipGaps), 20, false))
+	}
+	return units
+}
+
+func TestJackknife(t *testing.T) {
+	units := randomUnits(rand.New(rand.NewSource(1)), 6)
+	rs := Resampler{Method: Jackknife, Level: 0.95}
+	reps := rs.Replicates(units)
+	if len(reps) != len(units) {
+		t.Fatalf("Expect %d replicates, got %d", len(units), len(reps))
+	}
+	for k, rep := range reps {
+		expected := sites.NewStats(20, false)
+		for i, u := range units {
+			if i != k {
+				expected.Append(u)
+			}
+		}
+		e, g := NewCovResult(expected, 20), NewCovResult(rep, 20)
+		if math.Abs(e.Ks-g.Ks) > 1e-12 {
+			t.Errorf("Expect Ks %g leaving out unit %d, got %g", e.Ks, k, g.Ks)
+		}
+		for l := range e.Ct {
+			if math.Abs(e.Ct[l]-g.Ct[l]) > 1e-12 || math.Abs(e.Cm[l]-g.Cm[l]) > 1e-12 {
+				t.Errorf("Expect Ct %g and Cm %g at lag %d leaving out unit %d, got %g and %g", e.Ct[l], e.Cm[l], l, k, g.Ct[l], g.Cm[l])
+			}
+		}
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	units := randomUnits(rand.New(rand.NewSource(2)), 10)
+	total := sites.NewStats(20, false)
+	for _, u := range units {
+		total.Append(u)
+	}
+	c := NewCovResult(total, 20)
+
+	rs := Resampler{Method: Bootstrap, Reps: 200, Level: 0.9, Seed: 3}
+	r1, r2 := rs.Resample(c, units, 20), rs.Resample(c, units, 20)
+	if len(r1.Replicates) != 200 || len(r1.Ct) != len(c.Ct) || len(r1.Cm) != len(c.Cm) {
+		t.Fatalf("Expect 200 replicates and bands at %d lags, got %d, %d and %d", len(c.Ct), len(r1.Replicates), len(r1.Ct), len(r1.Cm))
+	}
+	if *r1.Ks != *r2.Ks || *r1.Ct[1] != *r2.Ct[1] {
+		t.Errorf("Expect the same bands of the same seed, got %+v and %+v", r1.Ks, r2.Ks)
+	}
+	if !(r1.Ks.Lo < c.Ks && c.Ks < r1.Ks.Hi) {
+		t.Errorf("Expect Ks %g within its band, got %+v", c.Ks, r1.Ks)
+	}
+}
+
+func TestBand(t *testing.T) {
+	rs := Resampler{Method: Bootstrap, Level: 0.5}
+	b := rs.Band(0, []float64{4, 3, math.NaN(), 1, 2, 5})
+	if b == nil || b.Lo != 2 || b.Hi != 4 {
+		t.Errorf("Expect band (2, 4), got %+v", b)
+	}
+
+	rs = Resampler{Method: Jackknife, Level: 0.95}
+	b = rs.Band(10, []float64{1, 3})
+	// se = sqrt(1/2 * 2) = 1.
+	if b == nil || math.Abs(b.Lo-(10-1.959964)) > 1e-6 || math.Abs(b.Hi-(10+1.959964)) > 1e-6 {
+		t.Errorf("Expect band 10 ± 1.96, got %+v", b)
+	}
+
+	if b = rs.Band(10, []float64{1, math.NaN()}); b != nil {
+		t.Errorf("Expect no band of a single replicate, got %+v", b)
+	}
+	if b = rs.Band(math.NaN(), []float64{1, 3}); b != nil {
+		t.Errorf("Expect no band of an undefined estimate, got %+v", b)
+	}
+}
+
+func TestWindows(t *testing.T) {
+	windows := Windows([][]byte{[]byte("ACGTA"), []byte("TGCAT")}, 2)
+	expected := []string{"AC TG", "GT CA", "A T"}
+	if len(windows) != len(expected) {
+		t.Fatalf("Expect %d windows, got %d", len(expected), len(windows))
+	}
+	for i, w := range windows {
+		if got := string(w[0]) + " " + string(w[1]); got != expected[i] {
+			t.Errorf("Expect window %q, got %q", expected[i], got)
+		}
+	}
+}
@@ -1,12 +1,37 @@
 package cmd
 
 import (
+	"encoding/json"
 	. "github.com/mingzhi/simmlst"
+	"math"
 )
 
 type Result struct {
-	Ps Config
-	C  CovResult
+	Ps      Config
+	C       CovResult
+	Seeds   []int64 // seeds of the replicates pooled in C.
+	Backend string  // simulator backend.
+	// Alignment is the path of the real alignment analysed instead of simulations.
+	Alignment string `json:",omitempty"`
+	// Blocks breaks the result down by alignment blocks, if asked.
+	Blocks []BlockResult `json:",omitempty"`
+	// CI is the confidence bands of C by resampling, if asked.
+	CI *Resampling `json:",omitempty"`
+}
+
+// BlockResult is the result of an alignment block of a run.
+type BlockResult struct {
+	Block int   // index of the block, in the order of the alignment.
+	Seed  int64 `json:",omitempty"` // seed of the run.
+	C     CovResult
+	Pairs []PairResult
+}
+
+// PairResult is the result of a pair of sequences of an alignment block.
+type PairResult struct {
+	I, J int // indices of the sequences in the block.
+	Ks   float64
+	Ct   []float64
 }
 
 type CovResult struct {
@@ -16,11 +41,88 @@ type CovResult struct {
 	Ct    []float64
 	CtN   []int
 	CtVar []float64
+	Cm    []float64 `json:",omitempty"` // mean lag covariances of pairs of sequences.
 }
 
 type FitResult struct {
-	B0, B1, B2                 float64
-	Func                       string
+	B0, B1, B2                 float64 // the first parameters of the model.
+	Func                       string  // name of the model.
+	Backend                    string
+	Alignment                  string `json:",omitempty"`
 	Theta, Rho, Ks             float64
 	N, NumGene, LenGene, Delta int
+	Blocks                     []int
+	PerSite                    bool
+	// lags fitted, from Start to End exclusive, and all parameters of the model.
+	Start, End int
+	ParamNames []string  `json:",omitempty"`
+	Params     []float64 `json:",omitempty"`
+	// weighted least squares of the parameters (see nls.Result).
+	Cov           [][]float64 `json:",omitempty"`
+	SE            []float64   `json:",omitempty"`
+	RSS, RedChiSq float64
+	DOF           int
+	Weighted      bool
+	Converged     bool
+	Status        string
+	// AIC and BIC, and their differences from the lowest of the fits
+	// of the parameter set to the same lags (see Compare).
+	// Infinite criteria, of fits never preferred, are null in JSON.
+	AIC, BIC           float64
+	DeltaAIC, DeltaBIC float64
+	AICWeight          float64
+	// Estimates of the parameter set, shared by its fits.
+	Estimates Estimates
+	// CI is the confidence bands of the fit, from fits of the replicates
+	// of the resampled result.
+	CI *FitBands `json:",omitempty"`
+}
+
+// FitBands is the confidence bands of fitted parameters.
+type FitBands struct {
+	Method     string
+	Level      float64
+	Replicates int // replicates fitted.
+	B0, B1, B2 *Band
+	Params     []*Band `json:",omitempty"`
+	Ks         *Band
+	// of the estimates.
+	RhoTheta, Delta, Phi *Band
+}
+
+// fitResult is a FitResult without its JSON methods.
+type fitResult FitResult
+
+// fitCriteria are the criteria of a FitResult in JSON, null if infinite.
+type fitCriteria struct {
+	fitResult
+	AIC, BIC           *float64
+	DeltaAIC, DeltaBIC *float64
+}
+
+func (f FitResult) MarshalJSON() ([]byte, error) {
+	finite := func(v float64) *float64 {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil
+		}
+		return &v
+	}
+	return json.Marshal(fitCriteria{fitResult(f), finite(f.AIC), finite(f.BIC), finite(f.DeltaAIC), finite(f.DeltaBIC)})
+}
+
+func (f *FitResult) UnmarshalJSON(data []byte) error {
+	var c fitCriteria
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	*f = FitResult(c.fitResult)
+	infinite := func(v *float64) float64 {
+		if v == nil {
+			return math.Inf(1)
+		}
+		return *v
+	}
+	f.AIC, f.BIC = infinite(c.AIC), infinite(c.BIC)
+	f.DeltaAIC, f.DeltaBIC = infinite(c.DeltaAIC), infinite(c.DeltaBIC)
+	return nil
 }
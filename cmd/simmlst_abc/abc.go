@@ -0,0 +1,288 @@
+// Infer Theta, Rho and Delta of an alignment by approximate Bayesian
+// computation over the simulator.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/mingzhi/biogo/seq"
+	. "github.com/mingzhi/simmlst"
+	"github.com/mingzhi/simmlst/abc"
+	. "github.com/mingzhi/simmlst/cmd"
+	"github.com/mingzhi/simmlst/cov"
+	"github.com/mingzhi/simmlst/sites"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+var (
+	ncpu    int
+	seed    int64
+	timeout time.Duration
+	backend string
+	program string
+	control string
+	input   string
+	output  string
+)
+
+func init() {
+	flag.IntVar(&ncpu, "ncpu", runtime.NumCPU(), "parallel simulations")
+	flag.Int64Var(&seed, "seed", 0, "seed (0 for a time-based seed)")
+	flag.DurationVar(&timeout, "timeout", 0, "timeout of each simulation (0 for no timeout)")
+	flag.StringVar(&backend, "backend", "native", "simulator backend ("+strings.Join(Backends, ", ")+")")
+	flag.StringVar(&program, "program", "", "path of the program of an external backend")
+	flag.Usage = func() {
+		log.Printf("usage: simmlst_abc [flags] control.json alignment out.json\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 3 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	control, input, output = flag.Arg(0), flag.Arg(1), flag.Arg(2)
+}
+
+// Control declares the inference.
+type Control struct {
+	// Config holds the fixed parameters; N and Blocks default
+	// to those of the alignment.
+	Config Config
+	// Priors of any of Theta, Rho and Delta, which override Config.
+	// Delta is drawn in sites, from at least 1.
+	Priors []abc.Prior
+	// Lags of Cm in the summaries, after Ks and Vd.
+	Lags     []int
+	Gaps     string // treatment of gaps, as simmlst_calc -gaps.
+	Distance string // euclidean, manhattan or chebyshev.
+
+	Method    string // rejection or smc.
+	Particles int    // particles of smc, or simulations of rejection.
+	Accept    float64
+	Schedule  abc.Schedule
+}
+
+// Output is the accepted posterior sample, and its summaries.
+type Output struct {
+	Control     Control
+	Observed    []float64 // summaries of the alignment.
+	Generations []Generation
+	Posterior   []abc.Summary
+	ESS         float64 // effective sample size.
+	Sample      []abc.Particle
+}
+
+// Generation is the tolerance of a population, and its cost.
+type Generation struct {
+	Tolerance   float64
+	Simulations int
+	Failed      int
+}
+
+func main() {
+	c, err := readControl(control)
+	if err != nil {
+		log.Fatalf("%s: %v\n", control, err)
+	}
+	policy, err := cov.ParseGapPolicy(c.Gaps)
+	if err != nil {
+		log.Fatalf("%s: %v\n", control, err)
+	}
+	distance, err := abc.ParseDistance(c.Distance)
+	if err != nil {
+		log.Fatalf("%s: %v\n", control, err)
+	}
+	simulator, err := NewSimulator(backend, program)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	geneGroups, _, err := ReadAlignment(input)
+	if err != nil {
+		panic(err)
+	}
+	if len(geneGroups) == 0 {
+		log.Fatalf("%s: no alignment blocks of two or more sequences\n", input)
+	}
+	if c.Config.N == 0 {
+		c.Config.N = len(geneGroups[0])
+	}
+	if len(c.Config.Blocks) == 0 && c.Config.NumGene == 0 {
+		for _, g := range geneGroups {
+			c.Config.Blocks = append(c.Config.Blocks, len(g[0].Seq))
+		}
+	}
+	observed := summarize(geneGroups, c.Lags, policy)
+	log.Printf("observed summaries: %v\n", observed)
+
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	log.Printf("seed: %d\n", seed)
+
+	sampler := &abc.Sampler{
+		Priors:   c.Priors,
+		Distance: distance,
+		Workers:  ncpu,
+		Seed:     seed,
+		Model: func(ctx context.Context, params []float64, seed int64) ([]float64, error) {
+			ps := c.Config
+			for i, p := range c.Priors {
+				set(&ps, p.Name, params[i])
+			}
+			ps.Seed = seed
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			geneGroups, err := simulator.Simulate(ctx, ps)
+			if err != nil {
+				return nil, err
+			}
+			return summarize(geneGroups, c.Lags, policy), nil
+		},
+		Log: func(params []float64, err error) {
+			log.Printf("skip failed simulation of %v: %v\n", params, err)
+		},
+	}
+
+	var pops []abc.Population
+	ctx := context.Background()
+	switch c.Method {
+	case "rejection":
+		var pop abc.Population
+		pop, err = sampler.Rejection(ctx, observed, c.Particles, c.Accept)
+		pops = []abc.Population{pop}
+	case "smc":
+		pops, err = sampler.SMC(ctx, observed, c.Particles, c.Schedule)
+	}
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	out := Output{Control: c, Observed: observed}
+	for _, pop := range pops {
+		out.Generations = append(out.Generations, Generation{pop.Tolerance, pop.Simulations, pop.Failed})
+		log.Printf("tolerance %g: %d simulations, %d failed\n", pop.Tolerance, pop.Simulations, pop.Failed)
+	}
+	posterior := pops[len(pops)-1]
+	out.Posterior, out.ESS = abc.Summarize(c.Priors, posterior)
+	out.Sample = posterior.Particles
+	write(output, out)
+}
+
+// readControl reads and checks a control file.
+func readControl(filename string) (Control, error) {
+	c := Control{Gaps: "skip", Distance: "euclidean", Method: "smc", Particles: 1000, Accept: 0.01}
+	c.Schedule = abc.Schedule{Quantile: 0.5, Generations: 5}
+	f, err := os.Open(filename)
+	if err != nil {
+		return c, err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&c); err != nil {
+		return c, err
+	}
+
+	if len(c.Priors) == 0 {
+		return c, fmt.Errorf("no priors")
+	}
+	for i, p := range c.Priors {
+		if p.Name == "Delta" {
+			if !(p.Min >= 1) {
+				return c, fmt.Errorf("prior of Delta from %g, expect at least 1", p.Min)
+			}
+			c.Priors[i].Integer = true
+			p.Integer = true
+		}
+		if err := p.Validate(); err != nil {
+			return c, err
+		}
+		if set(&Config{}, p.Name, p.Min) != nil {
+			return c, fmt.Errorf("prior of unknown parameter %q, expect Theta, Rho or Delta", p.Name)
+		}
+	}
+	for _, l := range c.Lags {
+		if l < 1 {
+			return c, fmt.Errorf("lag %d, expect positive lags", l)
+		}
+	}
+	if c.Particles < 1 {
+		return c, fmt.Errorf("%d particles", c.Particles)
+	}
+	switch c.Method {
+	case "rejection":
+		if !(c.Accept > 0 && c.Accept <= 1) {
+			return c, fmt.Errorf("accepted fraction %g, expect within (0, 1]", c.Accept)
+		}
+	case "smc":
+		if err := c.Schedule.Validate(); err != nil {
+			return c, err
+		}
+	default:
+		return c, fmt.Errorf("unknown method %q, expect rejection or smc", c.Method)
+	}
+	return c, nil
+}
+
+// set sets a parameter of a config; Delta is rounded to sites,
+// as drawn from its prior.
+func set(ps *Config, name string, v float64) error {
+	switch name {
+	case "Theta":
+		ps.Theta = v
+	case "Rho":
+		ps.Rho = v
+	case "Delta":
+		ps.Delta = int(math.Floor(v + 0.5))
+	default:
+		return fmt.Errorf("unknown parameter %q", name)
+	}
+	return nil
+}
+
+// summarize returns Ks, Vd, and Cm at lags of alignment blocks,
+// with lags within blocks as simmlst_calc.
+func summarize(geneGroups [][]*seq.Sequence, lags []int, policy cov.GapPolicy) []float64 {
+	maxl := 1
+	for _, l := range lags {
+		if l+1 > maxl {
+			maxl = l + 1
+		}
+	}
+	s := sites.NewStats(maxl, false)
+	for _, genes := range geneGroups {
+		var sequences [][]byte
+		for _, g := range genes {
+			sequences = append(sequences, g.Seq)
+		}
+		s.Add(sites.NewTable(sequences, policy))
+	}
+
+	summaries := []float64{s.GetKs(), s.GetVd()}
+	for _, l := range lags {
+		summaries = append(summaries, s.GetCm(l))
+	}
+	return summaries
+}
+
+func write(filename string, out Output) {
+	w, err := os.Create(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer w.Close()
+
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(out); err != nil {
+		panic(err)
+	}
+}
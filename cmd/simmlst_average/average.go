@@ -28,10 +28,13 @@ func main() {
 	m := average(resChan)
 
 	resArray := []Result{}
-	for ps, a := range m {
+	for _, a := range m {
 		res := Result{}
-		res.Ps = ps
+		res.Ps = a.Ps
+		res.Backend = a.Backend
+		res.Alignment = a.Alignment
 		res.C = a.ToCovResult()
+		res.Seeds = a.Seeds
 		resArray = append(resArray, res)
 	}
 
@@ -76,16 +79,20 @@ func write(resArray []Result, filename string) {
 	}
 }
 
-func average(resChan chan Result) map[ParameterSet]*averager {
-	m := make(map[ParameterSet]*averager)
+func average(resChan chan Result) map[string]*averager {
+	m := make(map[string]*averager)
 	for res := range resChan {
-		ps := res.Ps
+		ps := res.Backend + " " + res.Alignment + " " + res.Ps.Key()
 		a, found := m[ps]
 		if !found {
 			n := len(res.C.Ct)
 			a = newAverager(n)
+			a.Ps = res.Ps
+			a.Backend = res.Backend
+			a.Alignment = res.Alignment
 		}
 		a.Increment(res.C)
+		a.Seeds = append(a.Seeds, res.Seeds...)
 		m[ps] = a
 	}
 
@@ -93,8 +100,12 @@ func average(resChan chan Result) map[ParameterSet]*averager {
 }
 
 type averager struct {
-	Ks *meanvar.MeanVar
-	Ct []*meanvar.MeanVar
+	Ps        Config
+	Backend   string
+	Alignment string
+	Ks        *meanvar.MeanVar
+	Ct        []*meanvar.MeanVar
+	Seeds     []int64
 }
 
 func newAverager(n int) *averager {
@@ -2,90 +2,199 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"github.com/mingzhi/biogo/seq"
-	"github.com/mingzhi/gomath/stat/correlation"
-	"github.com/mingzhi/seqcor/calculator"
 	. "github.com/mingzhi/simmlst"
 	. "github.com/mingzhi/simmlst/cmd"
-	. "github.com/mingzhi/simmlst/io"
-	"io"
-	"io/ioutil"
+	"github.com/mingzhi/simmlst/cov"
+	"github.com/mingzhi/simmlst/sites"
+	"log"
 	"os"
 	"runtime"
+	"strings"
+	"time"
 )
 
 var (
-	maxl   int
-	ncpu   int
-	input  string
-	output string
+	maxl      int
+	ncpu      int
+	seed      int64
+	timeout   time.Duration
+	realAln   bool
+	gaps      string
+	stateFile string
+	breakdown bool
+	resample  string
+	reps      int
+	level     float64
+	window    int
+	resampler *Resampler
+	policy    cov.GapPolicy
+	input     string
+	output    string
+
+	backends                     string
+	simmlstPath, msPath, fsbPath string
 )
 
 func init() {
 	flag.IntVar(&maxl, "maxl", 1000, "maxl")
 	flag.IntVar(&ncpu, "ncpu", runtime.NumCPU(), "ncpu")
+	flag.Int64Var(&seed, "seed", 0, "master seed (0 for a time-based seed)")
+	flag.StringVar(&backends, "backend", "external", "comma-separated simulator backends ("+strings.Join(Backends, ", ")+")")
+	flag.StringVar(&simmlstPath, "simmlst", "", "path of simmlst")
+	flag.StringVar(&msPath, "ms", "", "path of ms")
+	flag.StringVar(&fsbPath, "fastsimbac", "", "path of fastSimBac")
+	flag.DurationVar(&timeout, "timeout", 0, "timeout of each simmlst run (0 for no timeout)")
+	flag.StringVar(&gaps, "gaps", "skip", "treatment of gaps: skip, state (a fifth nucleotide) or drop (columns with any gap)")
+	flag.StringVar(&stateFile, "state", "", "file to save the raw state of every run, for simmlst_merge")
+	flag.BoolVar(&breakdown, "breakdown", false, "also report every alignment block and pair of sequences")
+	flag.StringVar(&resample, "resample", "", "confidence bands by resampling genes, or windows: bootstrap or jackknife")
+	flag.IntVar(&reps, "reps", 1000, "bootstrap replicates")
+	flag.Float64Var(&level, "level", 0.95, "confidence level")
+	flag.IntVar(&window, "window", 0, "resample contiguous windows of this many columns, more than maxl, instead of genes (0 for genes)")
+	flag.BoolVar(&realAln, "alignment", false, "analyse an existing alignment (XMFA, FASTA, or a directory of FASTA files) instead of configs")
+}
+
+// parse parses the command line; main calls it, rather than init,
+// so that tests can run main in their binary.
+func parse() {
 	flag.Parse()
 	input = flag.Arg(0)
 	output = flag.Arg(1)
 
+	var err error
+	policy, err = cov.ParseGapPolicy(gaps)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	if resample != "" {
+		resampler = &Resampler{Method: resample, Reps: reps, Level: level, Seed: seed}
+		if err := resampler.Validate(); err != nil {
+			log.Fatalf("%v\n", err)
+		}
+	}
+	// pairs of columns across windows are left out of them,
+	// so every lag must fit within a window.
+	if window > 0 && window <= maxl {
+		log.Fatalf("windows of %d columns, expect more than maxl %d\n", window, maxl)
+	}
+
 	runtime.GOMAXPROCS(ncpu)
 }
 
 func main() {
-	psChan := read(input)
-	psMap := make(map[int][]Config)
-	for ps := range psChan {
-		psMap[ps.LenGene] = append(psMap[ps.LenGene], ps)
-	}
-	var results []Result
-	for seqLen, psSet := range psMap {
-		psChan := make(chan Config)
-		go func() {
-			defer close(psChan)
-			for _, ps := range psSet {
-				psChan <- ps
-			}
-		}()
-		resChan := run(psChan, seqLen)
+	parse()
+	if realAln {
+		finish([]Run{analyse(input)})
+		return
+	}
+
+	log.Printf("master seed: %d\n", seed)
+
+	paths := map[string]string{"external": simmlstPath, "ms": msPath, "fastsimbac": fsbPath}
+
+	psArr := seedConfigs(read(input), seed)
+	var runs []Run
+	for _, backend := range strings.Split(backends, ",") {
+		simulator, err := NewSimulator(backend, paths[backend])
+		if err != nil {
+			panic(err)
+		}
+
+		resChan := run(streamPS(psArr), simulator)
 		res := collect(resChan)
-		results = append(results, res...)
+		for i := range res {
+			res[i].Backend = backend
+		}
+		runs = append(runs, res...)
+	}
+	finish(runs)
+}
+
+// finish writes the state of runs, if asked, and their pooled results.
+func finish(runs []Run) {
+	if stateFile != "" {
+		if err := WriteState(stateFile, runs); err != nil {
+			panic(err)
+		}
+	}
+	results, err := PoolResampled(runs, maxl, resampler)
+	if err != nil {
+		log.Fatalf("%v\n", err)
 	}
 	write(output, results)
 }
 
-func read(filename string) chan Config {
-	f, err := os.Open(filename)
+// analyse calculates correlations of an existing alignment,
+// in the same way as simulated alignments.
+func analyse(path string) Run {
+	geneGroups, indices, err := ReadAlignment(path)
+	if err != nil {
+		panic(err)
+	}
+	if len(geneGroups) == 0 {
+		log.Fatalf("%s: no alignment blocks of two or more sequences\n", path)
+	}
+	log.Printf("%s: %d alignment blocks\n", path, len(geneGroups))
+
+	c, blocks, units := calcCorr(geneGroups)
+	// blocks are told apart by their indices in the file.
+	for i := range blocks {
+		blocks[i].Block = indices[blocks[i].Block]
+	}
+	return Run{Alignment: path, Stats: c, Blocks: blocks, Units: units}
+}
+
+// read reads configs from a .json or .ini file.
+func read(filename string) []Config {
+	psArr, err := ReadConfigs(filename)
 	if err != nil {
 		panic(err)
 	}
-	defer f.Close()
-	psArr := readConfigs(f)
-	return streamPS(psArr)
+	return psArr
 }
 
-func collect(resChan chan tempResult) []Result {
-	m := make(map[Config]*calculators)
-	for res := range resChan {
-		c, found := m[res.Ps]
+// seedConfigs gives every run without an explicit seed a seed
+// derived from the master seed, the index of its distinct config,
+// and the number of its replicate.
+func seedConfigs(psArr []Config, master int64) []Config {
+	indices := make(map[string]int)
+	replicates := make(map[string]int)
+	for i, ps := range psArr {
+		if ps.Seed != 0 {
+			continue
+		}
+		k := ps.Key()
+		index, found := indices[k]
 		if !found {
-			c = res.C
-		} else {
-			c.Append(res.C)
+			index = len(indices)
+			indices[k] = index
 		}
-		m[res.Ps] = c
+		psArr[i].Seed = DeriveSeed(master, index, replicates[k])
+		replicates[k]++
 	}
+	return psArr
+}
 
-	var results []Result
-	for ps, c := range m {
-		res := Result{}
-		res.Ps = ps
-		res.C = createCovResult(c, maxl)
-		results = append(results, res)
+// collect gathers successful runs.
+func collect(resChan chan tempResult) []Run {
+	var runs []Run
+	for res := range resChan {
+		if res.Err != nil {
+			log.Printf("skip failed run of %+v: %v\n", res.Ps, res.Err)
+			continue
+		}
+		runs = append(runs, Run{Ps: res.Ps, Stats: res.C, Blocks: res.Blocks, Units: res.Units})
 	}
-
-	return results
+	return runs
 }
 
 func write(filename string, results []Result) {
@@ -101,53 +210,26 @@ func write(filename string, results []Result) {
 	}
 }
 
-type calculators struct {
-	Ks *calculator.Ks
-	Ct *calculator.AutoCovFFTW
-}
-
-func (c *calculators) Append(c2 *calculators) {
-	c.Ks.Append(c2.Ks)
-	c.Ct.Append(c2.Ct)
-}
-
-func createCovResult(c *calculators, maxl int) CovResult {
-	var cr CovResult
-	cr.Ks = c.Ks.Mean.GetResult()
-	for i := 0; i < c.Ct.N && i < maxl; i++ {
-		cr.Ct = append(cr.Ct, c.Ct.GetResult(i))
-	}
-	return cr
-}
-
 type tempResult struct {
-	Ps Config
-	C  *calculators
+	Ps     Config
+	C      *sites.Stats
+	Blocks []BlockResult
+	Units  []*sites.Stats
+	Err    error
 }
 
-func run(psChan chan Config, seqLen int) chan tempResult {
+func run(psChan chan Config, simulator Simulator) chan tempResult {
 	ncpu := runtime.GOMAXPROCS(0)
 	numWorker := ncpu
 
-	circular := false
-	dft := correlation.NewFFTW(seqLen, circular)
-
 	resChan := make(chan tempResult)
 	done := make(chan bool)
 
 	worker := func() {
 		defer send(done)
 		for ps := range psChan {
-			tempfile, _ := ioutil.TempFile("", "simmlst")
-
-			Exec(ps, tempfile.Name())
-
-			geneGroups := readSequences(tempfile.Name())
-			c := calcCorr(geneGroups, &dft)
-
-			resChan <- tempResult{Ps: ps, C: c}
-
-			os.Remove(tempfile.Name())
+			c, blocks, units, err := simulate(simulator, ps)
+			resChan <- tempResult{Ps: ps, C: c, Blocks: blocks, Units: units, Err: err}
 		}
 	}
 
@@ -163,33 +245,61 @@ func run(psChan chan Config, seqLen int) chan tempResult {
 	return resChan
 }
 
-func readSequences(filename string) (geneGroups [][]*seq.Sequence) {
-	geneGroups = ReadXMFA(filename)
-	return
-}
+// simulate runs a simulation once and calculates correlations of the result.
+func simulate(simulator Simulator, ps Config) (*sites.Stats, []BlockResult, []*sites.Stats, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
-func calcCorr(geneGroups [][]*seq.Sequence, dft *correlation.FFTW) (c *calculators) {
-	for i := 0; i < len(geneGroups); i++ {
-		c1 := calcCorrOne(geneGroups[i], dft)
-		if i == 0 {
-			c = c1
-		} else {
-			c.Ct.Append(c1.Ct)
-			c.Ks.Append(c1.Ks)
-		}
+	// replicates must not overwrite exported trees.
+	geneGroups, err := simulator.Simulate(ctx, ps.WithSuffix(fmt.Sprintf(".%d", ps.Seed)))
+	if err != nil {
+		return nil, nil, nil, err
 	}
-	return
+
+	c, blocks, units := calcCorr(geneGroups)
+	return c, blocks, units, nil
 }
 
-func calcCorrOne(genes []*seq.Sequence, dft *correlation.FFTW) *calculators {
-	var c calculators
-	var sequences [][]byte
-	for _, g := range genes {
-		sequences = append(sequences, g.Seq)
+// calcCorr calculates correlations of alignment blocks,
+// with lags within blocks, their breakdown if asked,
+// and the units to resample if asked.
+func calcCorr(geneGroups [][]*seq.Sequence) (*sites.Stats, []BlockResult, []*sites.Stats) {
+	c := sites.NewStats(maxl, false)
+	var blocks []BlockResult
+	var units []*sites.Stats
+	for i, genes := range geneGroups {
+		var sequences [][]byte
+		for _, g := range genes {
+			sequences = append(sequences, g.Seq)
+		}
+		table := sites.NewTable(sequences, policy)
+
+		bc := sites.NewStats(maxl, false)
+		if breakdown {
+			pairs := bc.AddPairs(table)
+			if bc.Pairs > 0 {
+				blocks = append(blocks, NewBlockResult(i, bc, pairs, maxl))
+			}
+		} else {
+			bc.Add(table)
+		}
+		c.Append(bc)
+
+		if resampler != nil && bc.Pairs > 0 {
+			if window > 0 {
+				for _, w := range Windows(sequences, window) {
+					units = append(units, sites.Calc(sites.NewTable(w, policy), maxl, false))
+				}
+			} else {
+				units = append(units, bc)
+			}
+		}
 	}
-	c.Ct = calculator.CalcCtFFTW(sequences, dft)
-	c.Ks = calculator.CalcKs(sequences)
-	return &c
+	return c, blocks, units
 }
 
 func streamPS(psArr []Config) chan Config {
@@ -203,16 +313,6 @@ func streamPS(psArr []Config) chan Config {
 	return c
 }
 
-func readConfigs(r io.Reader) []Config {
-	var psArr []Config
-	decoder := json.NewDecoder(r)
-	err := decoder.Decode(&psArr)
-	if err != nil {
-		panic(err)
-	}
-	return psArr
-}
-
 func send(done chan bool) {
 	done <- true
 }
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	. "github.com/mingzhi/simmlst"
+	. "github.com/mingzhi/simmlst/cmd"
+	"github.com/mingzhi/simmlst/cov"
+	"github.com/mingzhi/simmlst/simtest"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	simtest.Main(main)
+	os.Exit(m.Run())
+}
+
+func TestCalc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simmlst_calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	program, err := simtest.Install(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ps := Config{Theta: 20, Rho: 10, N: 5, Delta: 50, NumGene: 2, LenGene: 300}
+	cfg := filepath.Join(dir, "test.ini")
+	if err := WriteConfigs(cfg, []Config{ps, ps}); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "out.json")
+	c, err := simtest.Command("-backend", "external,native", "-simmlst", program,
+		"-seed", "1", "-maxl", "50", "-ncpu", "1", cfg, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := c.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+
+	var results []Result
+	f, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expect results of 2 backends, got %d", len(results))
+	}
+	external, native := results[0], results[1]
+	if external.Backend != "external" {
+		external, native = native, external
+	}
+	if len(external.Seeds) != 2 || len(external.C.Ct) != 50 {
+		t.Errorf("Expect 2 replicates and 50 lags, got %d and %d", len(external.Seeds), len(external.C.Ct))
+	}
+
+	// the stand-in of simmlst simulates as the native backend.
+	if math.Abs(external.C.Ks-native.C.Ks) > 1e-12 || !(external.C.Ks > 0) {
+		t.Errorf("Expect the same Ks of both backends, got %g and %g", external.C.Ks, native.C.Ks)
+	}
+	for l := range external.C.Ct {
+		if math.Abs(external.C.Ct[l]-native.C.Ct[l]) > 1e-12 {
+			t.Errorf("Expect the same Ct of both backends at lag %d, got %g and %g", l, external.C.Ct[l], native.C.Ct[l])
+		}
+	}
+}
+
+func TestWindows(t *testing.T) {
+	ps := Config{Theta: 100, Rho: 50, N: 10, Delta: 50, NumGene: 1, LenGene: 5000, Seed: 1}
+	geneGroups, err := Native{}.Simulate(context.Background(), ps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(m, w int, p cov.GapPolicy, rs *Resampler) {
+		maxl, window, policy, resampler = m, w, p, rs
+	}(maxl, window, policy, resampler)
+	maxl, window, policy = 50, 500, cov.SkipGaps
+	resampler = &Resampler{Method: Bootstrap, Reps: 200, Level: 0.95, Seed: 1}
+	s, _, units := calcCorr(geneGroups)
+	c := NewCovResult(s, maxl)
+	r := resampler.Resample(c, units, maxl)
+	if r.Units != 10 || len(r.Ct) != maxl {
+		t.Fatalf("Expect 10 windows and bands at %d lags, got %d and %d", maxl, r.Units, len(r.Ct))
+	}
+	for l, b := range r.Ct {
+		if b == nil || c.Ct[l] < b.Lo || c.Ct[l] > b.Hi {
+			t.Errorf("Expect a band of Ct at lag %d around %g, got %v", l, c.Ct[l], b)
+		}
+	}
+
+	// windows no longer than the lags are rejected.
+	cmd, err := simtest.Command("-alignment", "-resample", "bootstrap", "-maxl", "50", "-window", "50", "in.xmfa", "out.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(out), "windows of 50 columns") {
+		t.Errorf("Expect an error of windows of 50 columns and maxl 50, got %v: %s", err, out)
+	}
+}
+
+func TestAlignmentBlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simmlst_calc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the block of a single sequence is left out,
+	// and the others keep their indices in the file.
+	xmfa := "> 1:1-8 +\nACGTACGT\n> 2:1-8 +\nACGAACTT\n> 3:1-8 +\nTCGTACGA\n=\n" +
+		"> 1:9-16 +\nACGTACGT\n=\n" +
+		"> 1:17-24 +\nGGCTAGCA\n> 2:17-24 +\nGGCAAGCT\n> 3:17-24 -\nAGCTAGCC\n=\n"
+	input := filepath.Join(dir, "in.xmfa")
+	if err := ioutil.WriteFile(input, []byte(xmfa), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "out.json")
+	c, err := simtest.Command("-alignment", "-breakdown", "-maxl", "5", input, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := c.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+
+	var results []Result
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || len(results[0].Blocks) != 2 {
+		t.Fatalf("Expect a result of 2 blocks, got %+v", results)
+	}
+	if b := results[0].Blocks; b[0].Block != 0 || b[1].Block != 2 {
+		t.Errorf("Expect blocks 0 and 2, got %d and %d", b[0].Block, b[1].Block)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"github.com/alecthomas/kingpin"
 	"github.com/mingzhi/simmlst"
+	"log"
 	"os"
+	"strings"
 )
 
 var (
@@ -23,6 +25,9 @@ func main() {
 	kingpin.Parse()
 	ps := parse(*cfgFile)
 	cs := create(ps)
+	if err := validate(cs); err != nil {
+		log.Fatal(err)
+	}
 
 	for _, c := range cs {
 		writeCfgJSON(c)
@@ -34,30 +39,70 @@ func main() {
 }
 
 // ParameterSet stores a set of parameters.
+// Block layouts are either given in Blocks, or generated from NumGenes
+// and LenGenes; Loci optionally names the blocks of every layout,
+// which must then have as many blocks.
+// PerSites lists the rate scalings to sweep (default per alignment),
+// and ClonalTree, LocalTrees and Graph make every config export them
+// to files named after its output.
 type ParameterSet struct {
 	Sizes    []int
 	NumGenes []int
 	LenGenes []int
+	Blocks   [][]int
+	Loci     []string
 	Thetas   []float64
 	Rhos     []float64
 	Deltas   []int
+	PerSites []bool
+
+	ClonalTree, LocalTrees, Graph bool
+}
+
+// layouts returns configs that only set the gene blocks.
+func layouts(par ParameterSet) []simmlst.Config {
+	var cfgs []simmlst.Config
+	if len(par.Blocks) > 0 {
+		for _, blocks := range par.Blocks {
+			var cfg simmlst.Config
+			cfg.Blocks = blocks
+			cfg.Loci = par.Loci
+			cfgs = append(cfgs, cfg)
+		}
+		return cfgs
+	}
+
+	for _, numGene := range par.NumGenes {
+		for _, lenGene := range par.LenGenes {
+			var cfg simmlst.Config
+			cfg.NumGene = numGene
+			cfg.LenGene = lenGene
+			cfg.Loci = par.Loci
+			cfgs = append(cfgs, cfg)
+		}
+	}
+	return cfgs
 }
 
 func create(par ParameterSet) []simmlst.Config {
+	perSites := par.PerSites
+	if len(perSites) == 0 {
+		perSites = []bool{false}
+	}
+
 	var cfgs []simmlst.Config
 	for _, size := range par.Sizes {
-		for _, numGene := range par.NumGenes {
-			for _, lenGene := range par.LenGenes {
-				for _, theta := range par.Thetas {
-					for _, rho := range par.Rhos {
-						for _, deltas := range par.Deltas {
-							var cfg simmlst.Config
+		for _, layout := range layouts(par) {
+			for _, theta := range par.Thetas {
+				for _, rho := range par.Rhos {
+					for _, deltas := range par.Deltas {
+						for _, perSite := range perSites {
+							cfg := layout
 							cfg.N = size
-							cfg.NumGene = numGene
-							cfg.LenGene = lenGene
 							cfg.Theta = theta
 							cfg.Rho = rho
 							cfg.Delta = deltas
+							cfg.PerSite = perSite
 							cfgs = append(cfgs, cfg)
 						}
 					}
@@ -69,11 +114,35 @@ func create(par ParameterSet) []simmlst.Config {
 	// add output prefix
 	for i := 0; i < len(cfgs); i++ {
 		cfgs[i].Output = fmt.Sprintf("%s_individual_%d", *prefix, i)
+		if par.ClonalTree {
+			cfgs[i].ClonalTree = cfgs[i].Output + ".clonal.nwk"
+		}
+		if par.LocalTrees {
+			cfgs[i].LocalTrees = cfgs[i].Output + ".local.nwk"
+		}
+		if par.Graph {
+			cfgs[i].Graph = cfgs[i].Output + ".dot"
+		}
 	}
 
 	return cfgs
 }
 
+// validate checks every config of the grid,
+// and reports all invalid ones at once.
+func validate(cfgs []simmlst.Config) error {
+	var msgs []string
+	for i, cfg := range cfgs {
+		if err := cfg.Validate(); err != nil {
+			msgs = append(msgs, fmt.Sprintf("config %d (%s): %v", i, cfg.Output, err))
+		}
+	}
+	if len(msgs) > 0 {
+		return fmt.Errorf("%d of %d configs are invalid:\n%s", len(msgs), len(cfgs), strings.Join(msgs, "\n"))
+	}
+	return nil
+}
+
 func parse(filename string) ParameterSet {
 	f, err := os.Open(filename)
 	if err != nil {
@@ -124,13 +193,9 @@ func writeCfgJSON(cfg simmlst.Config) {
 
 func writeCfgIni(cfg simmlst.Config) {
 	filename := cfg.Output + ".cfg.ini"
-	w, err := os.Create(filename)
-	if err != nil {
+	if err := simmlst.WriteConfigs(filename, []simmlst.Config{cfg}); err != nil {
 		panic(err)
 	}
-	defer w.Close()
-
-	w.WriteString(fmt.Sprintf("%s", cfg))
 }
 
 func writePbs(c simmlst.Config) {
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/mingzhi/simmlst/sites"
+)
+
+type Result struct {
+	Lag   int
+	Value float64
+	N     int
+	Type  string
+
+	// a breakdown result is of a single block, or pair of sequences,
+	// of the run of a seed, and is not averaged.
+	Breakdown bool
+	Seed      int64
+	Block     int
+	Pair      *sites.Pair
+}
+
+// calcCorr calculates correlation functions from an alignment:
+// Cm, Cm2 (Cm over Ks), Ks and Vd by pairs of sequences,
+// Ct pooled over pairs, and Cs, Cr and P2 by pairs of columns.
+// Lags wrap around the ends of the alignment.
+// It also returns the Ks and Cm of every pair of sequences.
+func calcCorr(alignment [][]byte, maxl int) (results []Result, pairs []sites.Pair) {
+	s := sites.NewStats(maxl, true)
+	pairs = s.AddPairs(sites.NewTable(alignment, policy))
+	if s.Pairs == 0 {
+		return
+	}
+
+	n := s.Pairs
+	ks := s.GetKs()
+	results = append(results, Result{Lag: 0, N: n, Type: "Ks", Value: ks})
+	results = append(results, Result{Lag: 0, N: n, Type: "Vd", Value: s.GetVd()})
+
+	for l := 0; l < maxl && l < len(alignment[0]); l++ {
+		cols := int(s.Columns[l])
+		results = append(results, []Result{
+			{Lag: l, N: n, Type: "Cm", Value: s.GetCm(l)},
+			{Lag: l, N: n, Type: "Cm2", Value: s.GetCm(l) / ks},
+			{Lag: l, N: n, Type: "Ct", Value: s.GetCt(l)},
+			{Lag: l, N: cols, Type: "Cs", Value: s.GetCs(l)},
+			{Lag: l, N: cols, Type: "Cr", Value: s.GetCr(l)},
+			{Lag: l, N: cols, Type: "P2", Value: s.GetP2(l)},
+		}...)
+	}
+
+	return
+}
+
+// breakdownResults returns the results of a block,
+// and of its pairs of sequences, for the breakdown.
+func breakdownResults(seed int64, block int, results []Result, pairs []sites.Pair) (breakdown []Result) {
+	for _, r := range results {
+		r.Breakdown, r.Seed, r.Block = true, seed, block
+		breakdown = append(breakdown, r)
+	}
+	for i := range pairs {
+		p := &pairs[i]
+		r := Result{Breakdown: true, Seed: seed, Block: block, Pair: p, N: p.Sites}
+		r.Type, r.Value = "Ks", p.Ks
+		breakdown = append(breakdown, r)
+		for l, cm := range p.Cm {
+			r.Type, r.Lag, r.Value = "Cm", l, cm
+			breakdown = append(breakdown, r)
+		}
+	}
+	return
+}
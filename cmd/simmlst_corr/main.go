@@ -1,36 +1,84 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"github.com/alecthomas/kingpin"
 	"github.com/mingzhi/biogo/seq"
 	"github.com/mingzhi/simmlst"
-	"io/ioutil"
+	"github.com/mingzhi/simmlst/cmd"
+	"github.com/mingzhi/simmlst/cov"
+	"log"
 	"math"
 	"os"
 	"runtime"
+	"time"
 )
 
 var (
-	cfgFile = kingpin.Arg("cfg", "population configure file").String()
+	cfgFile = kingpin.Arg("cfg", "population configure file, or alignment with --alignment").String()
 	outFile = kingpin.Arg("out", "out file").String()
 	ncpu    = kingpin.Flag("ncpu", "number of CPUs").Default("1").Int()
 	maxl    = kingpin.Flag("maxl", "max length of correlation").Default("100").Int()
 	repeat  = kingpin.Flag("repeat", "repeat").Default("1").Int()
+	seed    = kingpin.Flag("seed", "master seed (0 for the seed of the configure, or a time-based seed)").Default("0").Int64()
+	index   = kingpin.Flag("index", "index of the configure, used to derive seeds").Default("0").Int()
+	backend = kingpin.Flag("backend", "simulator backend (external, native, ms or fastsimbac)").Default("external").String()
+	program = kingpin.Flag("program", "path of the simulator program").Default("").String()
+	timeout = kingpin.Flag("timeout", "timeout of each simmlst run (0 for no timeout)").Default("0s").Duration()
+	gaps    = kingpin.Flag("gaps", "treatment of gaps: skip, state (a fifth nucleotide) or drop (columns with any gap)").Default("skip").String()
+	brkdown = kingpin.Flag("breakdown", "also write the results of every block and pair of sequences to <out>.breakdown.csv").Bool()
+	realAln = kingpin.Flag("alignment", "analyse an existing alignment (XMFA, FASTA, or a directory of FASTA files) instead of simulations").Bool()
 )
 
+// policy is the treatment of gaps.
+var policy cov.GapPolicy
+
 func main() {
 	kingpin.Parse()
 	runtime.GOMAXPROCS(*ncpu)
 
+	var err error
+	policy, err = cov.ParseGapPolicy(*gaps)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+
+	if *realAln {
+		res := collect(analyse(*cfgFile), *maxl, *outFile+".breakdown.csv")
+		write(res, *outFile)
+		return
+	}
+
 	cfg := readCfg(*cfgFile)
 
+	simulator, err := simmlst.NewSimulator(*backend, *program)
+	if err != nil {
+		panic(err)
+	}
+
+	master := *seed
+	if master == 0 {
+		master = cfg.Seed
+	}
+	if master == 0 {
+		master = time.Now().UnixNano()
+	}
+	log.Printf("master seed: %d\n", master)
+
+	seeds := make([]int64, *repeat)
+	for k := 0; k < *repeat; k++ {
+		seeds[k] = simmlst.DeriveSeed(master, *index, k)
+	}
+	writeSeeds(seeds, *outFile+".seeds.csv")
+
 	jobChan := make(chan simmlst.Config)
 	go func() {
 		defer close(jobChan)
 		for k := 0; k < *repeat; k++ {
-			jobChan <- cfg
+			c := cfg.WithSuffix(fmt.Sprintf(".%d", k))
+			c.Seed = seeds[k]
+			jobChan <- c
 		}
 	}()
 
@@ -39,7 +87,7 @@ func main() {
 	for k := 0; k < *ncpu; k++ {
 		go func() {
 			for c := range jobChan {
-				rc := runSimmlst(c)
+				rc := runSimmlst(simulator, c)
 				for r := range rc {
 					resChan <- r
 				}
@@ -55,65 +103,122 @@ func main() {
 		}
 	}()
 
-	res := collect(resChan, *maxl)
+	res := collect(resChan, *maxl, *outFile+".breakdown.csv")
 	write(res, *outFile)
 }
 
-// runSimmlst executes simmlst.
-func runSimmlst(cfg simmlst.Config) chan Result {
+// runSimmlst executes a simulation.
+func runSimmlst(simulator simmlst.Simulator, cfg simmlst.Config) chan Result {
 	resChan := make(chan Result)
 	go func() {
 		defer close(resChan)
-		// create tmp file.
-		tmp, _ := ioutil.TempFile("", "simmlst")
-		defer os.Remove(tmp.Name())
-		// execute simmlst.
-		simmlst.Exec(cfg, tmp.Name())
-		// collect simulation results and calculate correlations.
-		alignments := seq.ReadXMFA(tmp.Name())
-		for _, a := range alignments {
-			genes := []string{}
-			for _, g := range a {
-				genes = append(genes, string(g.Seq))
-			}
-			results := calcCorr(genes, *maxl)
-			for _, r := range results {
-				resChan <- r
+		// execute the simulator.
+		ctx := context.Background()
+		if *timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *timeout)
+			defer cancel()
+		}
+		alignments, err := simulator.Simulate(ctx, cfg)
+		if err != nil {
+			log.Printf("skip failed run with seed %d: %v\n", cfg.Seed, err)
+			return
+		}
+		for i, a := range alignments {
+			calcAlignment(cfg.Seed, i, a, resChan)
+		}
+	}()
+
+	return resChan
+}
+
+// analyse reads an existing alignment and calculates its correlations,
+// block by block, in the same way as simulated alignments.
+func analyse(path string) chan Result {
+	alignments, indices, err := cmd.ReadAlignment(path)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	log.Printf("%s: %d alignment blocks\n", path, len(alignments))
+
+	jobChan := make(chan int)
+	go func() {
+		defer close(jobChan)
+		for i := range alignments {
+			jobChan <- i
+		}
+	}()
+
+	resChan := make(chan Result)
+	done := make(chan bool)
+	for k := 0; k < *ncpu; k++ {
+		go func() {
+			for i := range jobChan {
+				calcAlignment(0, indices[i], alignments[i], resChan)
 			}
+			done <- true
+		}()
+	}
+
+	go func() {
+		defer close(resChan)
+		for k := 0; k < *ncpu; k++ {
+			<-done
 		}
 	}()
 
 	return resChan
 }
 
-// readCfg read and return a population configuration.
+// calcAlignment calculates correlations of an alignment block,
+// of the run of a seed, and their breakdown if asked.
+func calcAlignment(seed int64, block int, alignment []*seq.Sequence, resChan chan Result) {
+	genes := [][]byte{}
+	for _, g := range alignment {
+		genes = append(genes, g.Seq)
+	}
+	results, pairs := calcCorr(genes, *maxl)
+	if *brkdown {
+		results = append(results, breakdownResults(seed, block, results, pairs)...)
+	}
+	for _, r := range results {
+		resChan <- r
+	}
+}
+
+// readCfg read and return a population configuration,
+// from a .cfg.json or .cfg.ini file.
 func readCfg(file string) simmlst.Config {
-	f, err := os.Open(file)
+	c, err := simmlst.ReadConfig(file)
 	if err != nil {
 		panic(err)
 	}
-	defer f.Close()
-
-	var c simmlst.Config
-	decoder := json.NewDecoder(f)
-	if err := decoder.Decode(&c); err != nil {
-		panic(err)
+	if err := c.Validate(); err != nil {
+		log.Fatalf("%s: %v\n", file, err)
 	}
 	return c
 }
 
-// calcCorr calculates correlation functions from an alignment.
-func calcCorr(alignment []string, maxl int) (results []Result) {
-	cms := calcCmSub(alignment, maxl)
-	results = append(results, cms...)
-
-	return
-}
+// collect averages correlation results,
+// and writes breakdown results to a file.
+func collect(resChan chan Result, maxLen int, breakdownFile string) map[string][]*MeanVar {
+	var bw *os.File
+	if *brkdown {
+		var err error
+		bw, err = os.Create(breakdownFile)
+		if err != nil {
+			panic(err)
+		}
+		defer bw.Close()
+		bw.WriteString("seed,block,i,j,l,v,n,t\n")
+	}
 
-// collect averages correlation results.
-func collect(resChan chan Result, maxLen int) map[string][]*MeanVar {
 	resMap := make(map[string][]*MeanVar)
 	for res := range resChan {
+		if res.Breakdown {
+			writeBreakdown(bw, res)
+			continue
+		}
 		for len(resMap[res.Type]) <= res.Lag {
 			resMap[res.Type] = append(resMap[res.Type], NewMeanVar())
 		}
@@ -147,3 +252,27 @@ func write(result map[string][]*MeanVar, outFile string) {
 		}
 	}
 }
+
+// writeBreakdown writes a breakdown result;
+// sequences are blank for the results of a whole block.
+func writeBreakdown(w *os.File, res Result) {
+	i, j := "", ""
+	if res.Pair != nil {
+		i, j = fmt.Sprintf("%d", res.Pair.I), fmt.Sprintf("%d", res.Pair.J)
+	}
+	w.WriteString(fmt.Sprintf("%d,%d,%s,%s,%d,%g,%d,%s\n", res.Seed, res.Block, i, j, res.Lag, res.Value, res.N, res.Type))
+}
+
+// writeSeeds writes the seed of every replicate.
+func writeSeeds(seeds []int64, outFile string) {
+	w, err := os.Create(outFile)
+	if err != nil {
+		panic(err)
+	}
+	defer w.Close()
+
+	w.WriteString("replicate,seed\n")
+	for k, s := range seeds {
+		w.WriteString(fmt.Sprintf("%d,%d\n", k, s))
+	}
+}
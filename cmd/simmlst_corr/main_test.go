@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/csv"
+	"github.com/mingzhi/simmlst"
+	"github.com/mingzhi/simmlst/simtest"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	simtest.Main(main)
+	os.Exit(m.Run())
+}
+
+// readCSV reads the rows of a CSV file, without its header.
+func readCSV(t *testing.T, filename string) [][]string {
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows[1:]
+}
+
+func TestCorr(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simmlst_corr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	program, err := simtest.Install(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ps := simmlst.Config{Theta: 20, Rho: 10, N: 5, Delta: 50, NumGene: 2, LenGene: 300}
+	cfg := filepath.Join(dir, "test.ini")
+	if err := simmlst.WriteConfigs(cfg, []simmlst.Config{ps}); err != nil {
+		t.Fatal(err)
+	}
+
+	// results of the stand-in of simmlst, and of the native backend.
+	rows := make(map[string]map[string][]string)
+	for _, backend := range []string{"external", "native"} {
+		output := filepath.Join(dir, backend+".csv")
+		c, err := simtest.Command("--backend", backend, "--program", program,
+			"--repeat", "3", "--seed", "1", "--maxl", "30", "--ncpu", "1", cfg, output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, err := c.CombinedOutput(); err != nil {
+			t.Fatalf("%v: %s", err, out)
+		}
+
+		rows[backend] = make(map[string][]string)
+		for _, row := range readCSV(t, output) {
+			rows[backend][row[4]+" "+row[0]] = row
+		}
+		if seeds := readCSV(t, output+".seeds.csv"); len(seeds) != 3 {
+			t.Errorf("Expect the seeds of 3 replicates, got %d", len(seeds))
+		}
+	}
+
+	if len(rows["external"]) == 0 || len(rows["external"]) != len(rows["native"]) {
+		t.Fatalf("Expect the same rows of both backends, got %d and %d", len(rows["external"]), len(rows["native"]))
+	}
+	for key, row := range rows["external"] {
+		if n := rows["native"][key]; n == nil || n[1] != row[1] || n[3] != row[3] {
+			t.Errorf("Expect %s of the native backend, got %v", row, n)
+		}
+	}
+}
+
+// TestBreakdown checks that the breakdown pools back to the results:
+// the Ks and Cm of every block are the means of those of its pairs,
+// and the Ct of the results the mean of those of the blocks.
+func TestBreakdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simmlst_corr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ps := simmlst.Config{Theta: 20, Rho: 10, N: 5, Delta: 50, NumGene: 2, LenGene: 300}
+	cfg := filepath.Join(dir, "test.ini")
+	if err := simmlst.WriteConfigs(cfg, []simmlst.Config{ps}); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "out.csv")
+	c, err := simtest.Command("--backend", "native", "--breakdown",
+		"--repeat", "3", "--seed", "1", "--maxl", "30", "--ncpu", "2", cfg, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := c.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+
+	parse := func(s string) float64 {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	// sums and counts of the values of pairs, by block, type and lag,
+	// and of blocks, by type and lag.
+	type sum struct {
+		v float64
+		n int
+	}
+	pairs := make(map[string]*sum)
+	blocks := make(map[string]*sum)
+	blockValues := make(map[string]float64)
+	add := func(m map[string]*sum, key string, v float64) {
+		if m[key] == nil {
+			m[key] = &sum{}
+		}
+		m[key].v += v
+		m[key].n++
+	}
+	for _, row := range readCSV(t, output+".breakdown.csv") {
+		seed, block, i, l, v, typ := row[0], row[1], row[2], row[4], parse(row[5]), row[7]
+		key := seed + " " + block + " " + typ + " " + l
+		if i != "" {
+			add(pairs, key, v)
+			continue
+		}
+		blockValues[key] = v
+		add(blocks, typ+" "+l, v)
+	}
+	if len(pairs) == 0 || len(blocks) == 0 {
+		t.Fatalf("Expect a breakdown of blocks and pairs, got %d and %d rows", len(blocks), len(pairs))
+	}
+
+	for key, p := range pairs {
+		v, found := blockValues[key]
+		if !found {
+			t.Errorf("Expect a block of pairs %s", key)
+			continue
+		}
+		if p.n != 10 || math.Abs(p.v/float64(p.n)-v) > 1e-9*math.Max(1, math.Abs(v)) {
+			t.Errorf("Expect %s of the mean of 10 pairs %g, got %g of %d", key, p.v/float64(p.n), v, p.n)
+		}
+	}
+
+	cts := 0
+	for _, row := range readCSV(t, output) {
+		if row[4] != "Ct" {
+			continue
+		}
+		cts++
+		b := blocks["Ct "+row[0]]
+		if b == nil || b.n != 6 {
+			t.Errorf("Expect Ct at lag %s of 6 blocks, got %v", row[0], b)
+			continue
+		}
+		if m := parse(row[1]); math.Abs(b.v/float64(b.n)-m) > 1e-9*math.Max(1, math.Abs(m)) {
+			t.Errorf("Expect Ct at lag %s of the mean of blocks %g, got %g", row[0], b.v/float64(b.n), m)
+		}
+	}
+	if cts != 30 {
+		t.Errorf("Expect Ct at 30 lags, got %d", cts)
+	}
+}
@@ -0,0 +1,56 @@
+// Convert simulated XMFA alignments into the input formats
+// of other recombination inference methods.
+package main
+
+import (
+	"github.com/alecthomas/kingpin"
+	"github.com/mingzhi/biogo/seq"
+	. "github.com/mingzhi/simmlst/io"
+	"log"
+	"os"
+	"strings"
+)
+
+var (
+	input   = kingpin.Arg("in", "XMFA file, such as written by simmlst.Exec").Required().String()
+	prefix  = kingpin.Arg("prefix", "output prefix").Required().String()
+	formats = kingpin.Flag("format", "comma-separated formats ("+strings.Join(Formats, ", ")+")").Default("fasta").String()
+)
+
+// extensions maps formats to file extensions.
+var extensions = map[string]string{
+	"xmfa":   ".xmfa",
+	"fasta":  ".fasta",
+	"phylip": ".phy",
+	"nexus":  ".nex",
+	"vcf":    ".vcf",
+}
+
+func main() {
+	kingpin.Parse()
+
+	blocks, err := ReadXMFA(*input)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, format := range strings.Split(*formats, ",") {
+		ext, found := extensions[format]
+		if !found {
+			log.Fatalf("unknown format %q\n", format)
+		}
+		write(blocks, format, *prefix+ext)
+	}
+}
+
+func write(blocks [][]*seq.Sequence, format, filename string) {
+	w, err := os.Create(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer w.Close()
+
+	if err := WriteAlignment(w, format, blocks); err != nil {
+		panic(err)
+	}
+}
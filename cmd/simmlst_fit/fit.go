@@ -3,30 +3,42 @@ package main
 import (
 	"encoding/json"
 	"flag"
-	"github.com/mingzhi/meta/fit"
+	"fmt"
 	. "github.com/mingzhi/simmlst/cmd"
+	"log"
 	"math"
 	"os"
 	"runtime"
 )
 
 var (
-	ncpu   int
-	input  string
-	output string
+	ncpu     int
+	controls string
+	input    string
+	output   string
 )
 
 func init() {
 	flag.IntVar(&ncpu, "ncpu", runtime.NumCPU(), "ncpu")
-	flag.Parse()
+	flag.StringVar(&controls, "models", "", "JSON file of the models to fit, and their lags and bounds (default Coalescent and Hyper)")
+}
 
+// parse parses the command line; main calls it, rather than init,
+// so that tests can run in its binary.
+func parse() {
+	flag.Parse()
 	input = flag.Arg(0)
 	output = flag.Arg(1)
 }
 
 func main() {
+	parse()
+	fitControls, err := readControls(controls)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
 	resChan := read(input)
-	fitResults := batchFit(resChan)
+	fitResults := batchFit(resChan, fitControls)
 	write(fitResults, output)
 }
 
@@ -68,31 +80,70 @@ func write(fitResults []FitResult, filename string) {
 	}
 }
 
+// FitControl selects a model, the lags it is fitted to,
+// from Start to End exclusive (End < 0 for all lags), and bounds
+// of its parameters replacing those of the model, by their names.
 type FitControl struct {
-	FitFunc    FitFunc
+	Model      string
 	Start, End int
+	Bounds     map[string][2]float64 `json:",omitempty"`
 }
 
-func batchFit(resChan chan Result) []FitResult {
-	fitFuncMap := make(map[string]FitControl)
-	fitFuncMap["Exp"] = FitControl{FitFunc: fit.FitExp, Start: 1, End: -1}
-	fitFuncMap["Hyper"] = FitControl{FitFunc: fit.FitHyper, Start: 1, End: 10}
+// defaultControls fits Coalescent to all lags, which the estimates
+// are mapped from, and Hyper to short lags.
+var defaultControls = []FitControl{
+	{Model: "Coalescent", Start: 1, End: -1},
+	{Model: "Hyper", Start: 1, End: 10},
+}
 
+// readControls reads the controls of a JSON file, or the default ones.
+func readControls(filename string) ([]FitControl, error) {
+	if filename == "" {
+		return defaultControls, nil
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var controls []FitControl
+	if err := json.NewDecoder(f).Decode(&controls); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	for _, c := range controls {
+		m, err := LookupModel(c.Model)
+		if err == nil {
+			_, err = m.WithBounds(c.Bounds)
+		}
+		if err == nil && (c.Start < 0 || (c.End >= 0 && c.End <= c.Start)) {
+			err = fmt.Errorf("lags from %d to %d of model %s", c.Start, c.End, c.Model)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+	}
+	return controls, nil
+}
+
+func batchFit(resChan chan Result, controls []FitControl) []FitResult {
 	numWorker := ncpu
 	done := make(chan bool)
 	fitResChan := make(chan FitResult)
 	worker := func() {
 		defer send(done)
 		for res := range resChan {
-			for name, fitControl := range fitFuncMap {
-				fitFunc := fitControl.FitFunc
-				start := fitControl.Start
-				end := fitControl.End
-				if end < 0 {
-					end = len(res.C.Ct)
+			fits := fitAll(res, controls)
+			est := Estimate(fits)
+			var bands []*FitBands
+			if res.CI != nil {
+				bands = fitBands(fits, res.CI, controls)
+			}
+			for i, fitRes := range fits {
+				fitRes.Estimates = est
+				if bands != nil {
+					fitRes.CI = bands[i]
 				}
-				fitRes := doFit(res, fitFunc, start, end)
-				fitRes.Func = name
 				fitResChan <- fitRes
 			}
 		}
@@ -121,33 +172,112 @@ func send(done chan bool) {
 	done <- true
 }
 
-type FitFunc func(xdata, ydata []float64) (par []float64)
+// fitAll fits every model to a result, in the order of the controls,
+// and compares them.
+func fitAll(res Result, controls []FitControl) []FitResult {
+	var fits []FitResult
+	for _, c := range controls {
+		end := c.End
+		if end < 0 || end > len(res.C.Ct) {
+			end = len(res.C.Ct)
+		}
+		fits = append(fits, doFit(res, c, end))
+	}
+	Compare(fits)
+	return fits
+}
+
+// fitBands fits the replicates of a resampled result as the result,
+// and returns the confidence bands of the parameters of every fit,
+// and of the estimates, in the order of the fits.
+func fitBands(fits []FitResult, ci *Resampling, controls []FitControl) []*FitBands {
+	rs := Resampler{Method: ci.Method, Level: ci.Level}
+	params := make([][][]float64, len(fits))
+	for i, f := range fits {
+		params[i] = make([][]float64, len(f.Params))
+	}
+	var ks, rt, delta, phi []float64
+	fitted := 0
+	for _, c := range ci.Replicates {
+		// too short to fit.
+		if len(c.Ct) < 4 {
+			continue
+		}
+		fitted++
+		repFits := fitAll(Result{C: c}, controls)
+		for i, f := range repFits {
+			for j, v := range f.Params {
+				params[i][j] = append(params[i][j], v)
+			}
+		}
+		ks = append(ks, c.Ks)
 
-func doFit(res Result, fitFunc FitFunc, start, end int) FitResult {
-	var fitRes FitResult
-	var xdata, ydata []float64
-	for i := start; i < end; i++ {
-		v := res.C.Ct[i]
-		if !math.IsNaN(v) {
-			xdata = append(xdata, float64(i))
-			ydata = append(ydata, v)
+		// replicates of undefined estimates are left out.
+		est := Estimate(repFits)
+		rt = append(rt, value(est.RhoTheta))
+		delta = append(delta, value(est.Delta))
+		phi = append(phi, value(est.Phi))
+	}
+	est := Estimate(fits)
+
+	var bands []*FitBands
+	for i, f := range fits {
+		b := &FitBands{
+			Method:     ci.Method,
+			Level:      ci.Level,
+			Replicates: fitted,
+			Ks:         rs.Band(f.Ks, ks),
+			RhoTheta:   rs.Band(value(est.RhoTheta), rt),
+			Delta:      rs.Band(value(est.Delta), delta),
+			Phi:        rs.Band(value(est.Phi), phi),
+		}
+		for j, v := range f.Params {
+			b.Params = append(b.Params, rs.Band(v, params[i][j]))
 		}
+		if len(b.Params) > 0 {
+			b.B0 = b.Params[0]
+		}
+		if len(b.Params) > 1 {
+			b.B1 = b.Params[1]
+		}
+		if len(b.Params) > 2 {
+			b.B2 = b.Params[2]
+		}
+		bands = append(bands, b)
+	}
+	return bands
+}
+
+// value returns an estimate, or NaN if undefined.
+func value(v *float64) float64 {
+	if v == nil {
+		return math.NaN()
 	}
+	return *v
+}
 
-	par := fitFunc(xdata, ydata)
-	fitRes.B0 = par[0]
-	fitRes.B1 = par[1]
-	if len(par) > 2 {
-		fitRes.B2 = par[2]
+// doFit fits the model of a control to Ct of a result, up to lag end.
+func doFit(res Result, c FitControl, end int) FitResult {
+	m, err := LookupModel(c.Model)
+	if err == nil {
+		m, err = m.WithBounds(c.Bounds)
+	}
+	if err != nil {
+		panic(err)
 	}
+	fitRes := m.Fit(res.C, c.Start, end)
 
 	fitRes.Delta = res.Ps.Delta
 	fitRes.LenGene = res.Ps.LenGene
 	fitRes.N = res.Ps.N
 	fitRes.NumGene = res.Ps.NumGene
+	fitRes.Blocks = res.Ps.Blocks
+	fitRes.PerSite = res.Ps.PerSite
 	fitRes.Rho = res.Ps.Rho
 	fitRes.Theta = res.Ps.Theta
 	fitRes.Ks = res.C.Ks
+	fitRes.Backend = res.Backend
+	fitRes.Alignment = res.Alignment
 
 	return fitRes
 }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	. "github.com/mingzhi/simmlst/cmd"
+	"github.com/mingzhi/simmlst/theory"
+	"testing"
+)
+
+func TestFitBandsUndefined(t *testing.T) {
+	controls := []FitControl{{Model: "Coalescent", Start: 1, End: -1}}
+	ci := &Resampling{Method: Jackknife, Level: 0.95}
+	for k := 0; k < 5; k++ {
+		p := theory.Params{N: 10, Theta: 0.05, Rho: 0.01 * (1 + 0.05*float64(k)), Delta: 50, Blocks: []int{1000}}
+		c := CovResult{Ks: p.Ks()}
+		for l := 0; l < 50; l++ {
+			c.Ct = append(c.Ct, p.Cov(l))
+		}
+		ci.Replicates = append(ci.Replicates, c)
+	}
+
+	// the estimates of the replicates are defined, but not that of the alignment.
+	fits := fitAll(Result{C: ci.Replicates[0]}, controls)
+	fits[0].Converged = false
+	if e := Estimate(fits); e.Delta != nil {
+		t.Fatalf("Expect delta undefined, got %g", *e.Delta)
+	}
+	bands := fitBands(fits, ci, controls)
+	if len(bands) != 1 || bands[0].Delta != nil || bands[0].RhoTheta != nil {
+		t.Errorf("Expect no bands of undefined estimates, got %+v", bands[0])
+	}
+	if bands[0].Ks == nil {
+		t.Errorf("Expect a band of Ks")
+	}
+	if _, err := json.Marshal(bands); err != nil {
+		t.Errorf("Expect bands encoded in JSON, got %v", err)
+	}
+}
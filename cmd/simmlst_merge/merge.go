@@ -0,0 +1,90 @@
+// Merge the states saved by simmlst_calc -state, and pool their runs.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	. "github.com/mingzhi/simmlst/cmd"
+	"log"
+	"os"
+)
+
+var (
+	maxl      int
+	stateFile string
+	resample  string
+	reps      int
+	level     float64
+	seed      int64
+	output    string
+	inputs    []string
+)
+
+func init() {
+	flag.IntVar(&maxl, "maxl", 1000, "maxl")
+	flag.StringVar(&stateFile, "state", "", "file to save the merged state")
+	flag.StringVar(&resample, "resample", "", "confidence bands by resampling the units saved with simmlst_calc -resample: bootstrap or jackknife")
+	flag.IntVar(&reps, "reps", 1000, "bootstrap replicates")
+	flag.Float64Var(&level, "level", 0.95, "confidence level")
+	flag.Int64Var(&seed, "seed", 1, "seed of the bootstrap")
+	flag.Usage = func() {
+		log.Printf("usage: simmlst_merge [flags] out.json state.json...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	output = flag.Arg(0)
+	inputs = flag.Args()[1:]
+}
+
+func main() {
+	var runs []Run
+	for _, filename := range inputs {
+		r, err := ReadState(filename)
+		if err != nil {
+			log.Fatalf("%v\n", err)
+		}
+		runs = append(runs, r...)
+	}
+	log.Printf("%d runs from %d states\n", len(runs), len(inputs))
+
+	var resampler *Resampler
+	if resample != "" {
+		resampler = &Resampler{Method: resample, Reps: reps, Level: level, Seed: seed}
+		if err := resampler.Validate(); err != nil {
+			log.Fatalf("%v\n", err)
+		}
+		for _, r := range runs {
+			if len(r.Units) == 0 {
+				log.Fatalf("run of %+v has no units to resample; save states with simmlst_calc -resample\n", r.Ps)
+			}
+		}
+	}
+
+	if stateFile != "" {
+		if err := WriteState(stateFile, runs); err != nil {
+			panic(err)
+		}
+	}
+	results, err := PoolResampled(runs, maxl, resampler)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	write(output, results)
+}
+
+func write(filename string, results []Result) {
+	w, err := os.Create(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer w.Close()
+
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(results); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,145 @@
+// Overlay the expectations of the coalescent with gene conversion
+// onto the averages of simmlst_average, with the z-scores of their
+// differences at every lag.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	. "github.com/mingzhi/simmlst"
+	. "github.com/mingzhi/simmlst/cmd"
+	"github.com/mingzhi/simmlst/theory"
+	"log"
+	"math"
+	"os"
+)
+
+var (
+	maxl   int
+	input  string
+	output string
+)
+
+func init() {
+	flag.IntVar(&maxl, "maxl", 0, "maximum lag (0 for the lags of the averages)")
+	flag.Usage = func() {
+		log.Printf("usage: simmlst_theory [flags] averages.json out.json\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	input, output = flag.Arg(0), flag.Arg(1)
+}
+
+// Overlay is the averages of a parameter set beside their expectations.
+type Overlay struct {
+	Ps       Config
+	Backend  string
+	Expected theory.Expectations
+	Ks       Point
+	Ct       []Point
+}
+
+// Point is an average over replicates and its standard error,
+// its expectation, and the z-score of their difference,
+// nil without a standard error.
+type Point struct {
+	Observed, SE, Expected float64
+	Z                      *float64 `json:",omitempty"`
+}
+
+func main() {
+	var overlays []Overlay
+	for _, res := range read(input) {
+		if res.Alignment != "" {
+			log.Printf("skip the alignment %s, which has no parameters\n", res.Alignment)
+			continue
+		}
+		n := maxl
+		if n == 0 || n > len(res.C.Ct) {
+			n = len(res.C.Ct)
+		}
+		e, err := params(res.Ps).Expect(n)
+		if err != nil {
+			log.Fatalf("%s: %v\n", res.Ps.Key(), err)
+		}
+
+		o := Overlay{Ps: res.Ps, Backend: res.Backend, Expected: e}
+		o.Ks = point(res.C.Ks, res.C.KsVar, res.C.KsN, e.Ks)
+		worst := -1
+		for l := 0; l < n; l++ {
+			var v float64
+			var k int
+			if l < len(res.C.CtVar) && l < len(res.C.CtN) {
+				v, k = res.C.CtVar[l], res.C.CtN[l]
+			}
+			p := point(res.C.Ct[l], v, k, e.Ct[l])
+			if p.Z != nil && (worst < 0 || math.Abs(*p.Z) > math.Abs(*o.Ct[worst].Z)) {
+				worst = l
+			}
+			o.Ct = append(o.Ct, p)
+		}
+		if worst >= 0 {
+			log.Printf("%s: largest |z| of Ct %.2f at lag %d\n", res.Ps.Key(), math.Abs(*o.Ct[worst].Z), worst)
+		}
+		overlays = append(overlays, o)
+	}
+	write(overlays, output)
+}
+
+// params returns the parameters of the model of a config,
+// with rates per site.
+func params(ps Config) theory.Params {
+	p := theory.Params{N: ps.N, Delta: float64(ps.Delta), Blocks: ps.BlockLengths()}
+	length := 0
+	for _, l := range p.Blocks {
+		length += l
+	}
+	theta, rho := ps.Rates()
+	if length > 0 {
+		p.Theta, p.Rho = theta/float64(length), rho/float64(length)
+	}
+	return p
+}
+
+// point compares an average of n replicates of variance v to its expectation.
+func point(average, v float64, n int, expected float64) Point {
+	p := Point{Observed: average, Expected: expected}
+	if n > 1 && v > 0 {
+		p.SE = math.Sqrt(v / float64(n))
+		z := (average - expected) / p.SE
+		p.Z = &z
+	}
+	return p
+}
+
+func read(filename string) []Result {
+	var results []Result
+	f, err := os.Open(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	d := json.NewDecoder(f)
+	if err := d.Decode(&results); err != nil {
+		panic(err)
+	}
+	return results
+}
+
+func write(overlays []Overlay, filename string) {
+	w, err := os.Create(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer w.Close()
+
+	e := json.NewEncoder(w)
+	if err := e.Encode(overlays); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/mingzhi/simmlst"
+	"github.com/mingzhi/simmlst/sites"
+	"os"
+	"sort"
+)
+
+// StateVersion is the version of state files.
+// State files of other versions are rejected when read.
+const StateVersion = 2
+
+// Run is the raw state of the correlations of a simulation,
+// or of an existing alignment.
+type Run struct {
+	Ps        Config
+	Backend   string `json:",omitempty"`
+	Alignment string `json:",omitempty"`
+	Stats     *sites.Stats
+	Blocks    []BlockResult `json:",omitempty"`
+	// Units is the statistics of the genes, or windows, of the run,
+	// kept for resampling.
+	Units []*sites.Stats `json:",omitempty"`
+}
+
+// State is the content of a state file.
+type State struct {
+	Version int
+	Runs    []Run
+}
+
+// WriteState writes runs to a state file.
+func WriteState(filename string, runs []Run) error {
+	w, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := json.NewEncoder(w).Encode(State{Version: StateVersion, Runs: runs}); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// ReadState reads the runs of a state file.
+func ReadState(filename string) ([]Run, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var s State
+	if err := json.NewDecoder(f).Decode(&s); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if s.Version != StateVersion {
+		return nil, fmt.Errorf("%s: state version %d, expect %d", filename, s.Version, StateVersion)
+	}
+	for i, r := range s.Runs {
+		if r.Stats == nil {
+			return nil, fmt.Errorf("%s: run %d has no statistics", filename, i+1)
+		}
+		for _, st := range append([]*sites.Stats{r.Stats}, r.Units...) {
+			if err := st.Validate(); err != nil {
+				return nil, fmt.Errorf("%s: run %d: %v", filename, i+1, err)
+			}
+		}
+	}
+	return s.Runs, nil
+}
+
+// Pool pools the runs of the same config, backend and alignment,
+// which differ only by seeds. Runs are pooled in the order of their seeds,
+// so results do not depend on how runs were split between processes.
+// Runs pooled together must have statistics of the same maximum lag,
+// and both be circular or not.
+func Pool(runs []Run, maxl int) ([]Result, error) {
+	return PoolResampled(runs, maxl, nil)
+}
+
+// PoolResampled pools runs as Pool, and gives every result
+// the confidence bands of resampling the units of its runs, if rs is not nil.
+func PoolResampled(runs []Run, maxl int, rs *Resampler) ([]Result, error) {
+	key := func(r Run) string {
+		ps := r.Ps
+		ps.Seed = 0
+		return r.Backend + " " + r.Alignment + " " + ps.Key()
+	}
+
+	sorted := make([]Run, len(runs))
+	copy(sorted, runs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ki, kj := key(sorted[i]), key(sorted[j])
+		if ki != kj {
+			return ki < kj
+		}
+		return sorted[i].Ps.Seed < sorted[j].Ps.Seed
+	})
+
+	var results []Result
+	var pooled *sites.Stats
+	var units []*sites.Stats
+	finish := func() {
+		res := &results[len(results)-1]
+		res.C = NewCovResult(pooled, maxl)
+		if rs != nil {
+			res.CI = rs.Resample(res.C, units, maxl)
+		}
+	}
+	for i, r := range sorted {
+		if i == 0 || key(r) != key(sorted[i-1]) {
+			if pooled != nil {
+				finish()
+			}
+			ps := r.Ps
+			ps.Seed = 0
+			results = append(results, Result{Ps: ps, Backend: r.Backend, Alignment: r.Alignment})
+			pooled = sites.NewStats(r.Stats.MaxL, r.Stats.Circular)
+			units = nil
+		}
+		for _, st := range append([]*sites.Stats{r.Stats}, r.Units...) {
+			if st.MaxL != pooled.MaxL || st.Circular != pooled.Circular {
+				return nil, fmt.Errorf("runs of %s with maxl %d and %d, circular %v and %v; pool runs of the same -maxl",
+					key(r), pooled.MaxL, st.MaxL, pooled.Circular, st.Circular)
+			}
+		}
+		pooled.Append(r.Stats)
+		units = append(units, r.Units...)
+		res := &results[len(results)-1]
+		if r.Ps.Seed != 0 {
+			res.Seeds = append(res.Seeds, r.Ps.Seed)
+		}
+		for _, b := range r.Blocks {
+			b.Seed = r.Ps.Seed
+			res.Blocks = append(res.Blocks, b)
+		}
+	}
+	if pooled != nil {
+		finish()
+	}
+
+	return results, nil
+}
+
+// NewBlockResult returns the result of an alignment block,
+// with those of its pairs of sequences.
+func NewBlockResult(block int, s *sites.Stats, pairs []sites.Pair, maxl int) BlockResult {
+	b := BlockResult{Block: block, C: NewCovResult(s, maxl)}
+	for _, p := range pairs {
+		pr := PairResult{I: p.I, J: p.J, Ks: p.Ks, Ct: p.Cm}
+		if len(pr.Ct) > maxl {
+			pr.Ct = pr.Ct[:maxl]
+		}
+		b.Pairs = append(b.Pairs, pr)
+	}
+	return b
+}
+
+// NewCovResult returns Ks and Ct pooled over pairs of sequences,
+// and Cm averaged over them, up to the longest lag within blocks.
+func NewCovResult(s *sites.Stats, maxl int) CovResult {
+	var cr CovResult
+	cr.Ks = s.GetPooledKs()
+	for i := 0; i < s.MaxL && i < maxl && s.SitePairs[i] > 0; i++ {
+		cr.Ct = append(cr.Ct, s.GetCt(i))
+		cr.Cm = append(cr.Cm, s.GetCm(i))
+	}
+	return cr
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	. "github.com/mingzhi/simmlst"
+	"github.com/mingzhi/simmlst/cov"
+	"github.com/mingzhi/simmlst/sites"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMergeStates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := rand.New(rand.NewSource(1))
+	ps := Config{Theta: 10, Rho: 5, N: 4, Delta: 20, NumGene: 1, LenGene: 100}
+	var runs []Run
+	for k := 0; k < 6; k++ {
+		var sequences [][]byte
+		for i := 0; i < ps.N; i++ {
+			s := make([]byte, ps.LenGene)
+			for j := range s {
+				s[j] = "AACGT-"[r.Intn(6)]
+			}
+			sequences = append(sequences, s)
+		}
+		run := Run{Ps: ps, Backend: "native", Stats: sites.Calc(sites.NewTable(sequences, cov.SkipGaps), 30, false)}
+		run.Ps.Seed = int64(k + 1)
+		runs = append(runs, run)
+	}
+
+	// save runs in two states, and merge them in the reverse order.
+	files := []string{filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json")}
+	if err := WriteState(files[0], []Run{runs[4], runs[0], runs[2]}); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteState(files[1], []Run{runs[5], runs[1], runs[3]}); err != nil {
+		t.Fatal(err)
+	}
+	var merged []Run
+	for i := len(files) - 1; i >= 0; i-- {
+		rs, err := ReadState(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		merged = append(merged, rs...)
+	}
+
+	expected, err := Pool(runs, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Pool(merged, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expect %+v, got %+v", expected, got)
+	}
+	if len(got) != 1 || !reflect.DeepEqual(got[0].Seeds, []int64{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("Expect a result of seeds 1 to 6, got %+v", got)
+	}
+
+	// runs of other lags are not pooled, nor read with statistics of other lags.
+	other := runs[0]
+	other.Ps.Seed = 7
+	other.Stats = sites.NewStats(20, false)
+	if _, err := Pool(append(runs, other), 30); err == nil {
+		t.Errorf("Expect an error of pooling runs of maxl 30 and 20")
+	}
+	other.Stats.Cm = other.Stats.Cm[:10]
+	if err := WriteState(files[0], []Run{other}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadState(files[0]); err == nil {
+		t.Errorf("Expect an error of a state of statistics of 10 and 20 lags")
+	}
+}
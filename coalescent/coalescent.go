@@ -0,0 +1,365 @@
+// Package coalescent simulates bacterial alignments under the
+// coalescent with gene conversion, the model of SimMLST.
+//
+// A clonal genealogy of N isolates is drawn from the Kingman coalescent,
+// in which every pair of lineages coalesces at rate 1.
+// Recombination events hit the branches of the clonal genealogy
+// at rate Rho/2 per unit of time.
+// Each event imports a tract starting at a uniform site,
+// with geometric length of mean Delta, truncated at the end of its block,
+// from a donor lineage that coalesces with the clonal genealogy
+// at rate k(t), the number of clonal lineages at time t.
+// Mutations occur at rate Theta/2 per unit of time on every lineage,
+// including the recombinant edges, at uniform sites,
+// under the Jukes-Cantor model.
+// Theta and Rho are scaled rates of the whole alignment,
+// not per site.
+package coalescent
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Bases are the nucleotides used by the Jukes-Cantor model.
+const Bases = "ACGT"
+
+// Params stores the parameters of a simulation.
+type Params struct {
+	N          int     // number of isolates.
+	Theta, Rho float64 // scaled mutation and recombination rates.
+	Delta      int     // mean length of imports.
+	Blocks     []int   // lengths of the blocks.
+}
+
+// Validate checks the parameters.
+func (p Params) Validate() error {
+	if p.N < 2 {
+		return errors.New("coalescent: N must be at least 2")
+	}
+	if p.Theta < 0 || p.Rho < 0 {
+		return errors.New("coalescent: Theta and Rho must not be negative")
+	}
+	if p.Delta <= 0 {
+		return errors.New("coalescent: Delta must be positive")
+	}
+	if len(p.Blocks) == 0 {
+		return errors.New("coalescent: no blocks")
+	}
+	for _, l := range p.Blocks {
+		if l <= 0 {
+			return errors.New("coalescent: block lengths must be positive")
+		}
+	}
+	return nil
+}
+
+// Simulate returns the sequences of the N isolates in every block.
+// It checks ctx between the stages of the simulation and as it goes
+// through them, and stops with the error of ctx once it is done.
+func Simulate(ctx context.Context, p Params, rng *rand.Rand) ([][][]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	var s simulation
+	s.Params = p
+	s.rng = rng
+	for _, l := range p.Blocks {
+		s.starts = append(s.starts, s.length)
+		s.length += l
+	}
+	s.nodes = genealogy(p.N, rng)
+	if err := s.addRecombinations(ctx); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.addMutations()
+	s.addSplits()
+	genome, err := s.evolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := make([][][]byte, len(p.Blocks))
+	for b, start := range s.starts {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for i := 0; i < p.N; i++ {
+			seq := make([]byte, p.Blocks[b])
+			copy(seq, genome[i][start:start+p.Blocks[b]])
+			blocks[b] = append(blocks[b], seq)
+		}
+	}
+
+	return blocks, nil
+}
+
+// node is a node of the clonal genealogy.
+// Nodes 0 to N-1 are the isolates, and the last node is the root.
+type node struct {
+	time   float64
+	parent int // -1 for the root.
+}
+
+// genealogy draws a clonal genealogy from the Kingman coalescent.
+func genealogy(n int, rng *rand.Rand) []node {
+	nodes := make([]node, n, 2*n-1)
+	lineages := make([]int, n)
+	for i := 0; i < n; i++ {
+		nodes[i].parent = -1
+		lineages[i] = i
+	}
+
+	t := 0.0
+	for k := n; k > 1; k-- {
+		t += rng.ExpFloat64() / float64(k*(k-1)/2)
+		a := rng.Intn(k)
+		b := rng.Intn(k - 1)
+		if b >= a {
+			b++
+		}
+
+		parent := len(nodes)
+		nodes = append(nodes, node{time: t, parent: -1})
+		nodes[lineages[a]].parent = parent
+		nodes[lineages[b]].parent = parent
+
+		lineages[a] = parent
+		lineages[b] = lineages[k-1]
+		lineages = lineages[:k-1]
+	}
+
+	return nodes
+}
+
+// event kinds, ordered so that events at equal times
+// are processed in a consistent order.
+const (
+	split = iota
+	donate
+	receive
+	mutate
+)
+
+// event happens on the lineage above node at time.
+type event struct {
+	time float64
+	kind int
+	node int
+	site int
+	imp  int // index of the import of donate and receive events.
+}
+
+// tract is an imported stretch of the genome.
+type tract struct {
+	start, end int
+	from, to   float64 // donor and recipient times.
+	seq        []byte  // state of the donor, copied at the donor time.
+}
+
+type simulation struct {
+	Params
+	rng     *rand.Rand
+	starts  []int // start positions of the blocks.
+	length  int   // total length.
+	nodes   []node
+	events  []event
+	imports []tract
+	top     float64 // time above which nothing happens on the root lineage.
+}
+
+func (s *simulation) root() int {
+	return len(s.nodes) - 1
+}
+
+// lineages returns the nodes whose lineages exist at time t.
+func (s *simulation) lineages(t float64) []int {
+	var ls []int
+	for i, nd := range s.nodes {
+		if nd.time <= t && (nd.parent < 0 || t < s.nodes[nd.parent].time) {
+			ls = append(ls, i)
+		}
+	}
+	return ls
+}
+
+// attach draws the time at which a donor lineage leaving time t
+// coalesces with the clonal genealogy, and the node of the lineage it joins.
+func (s *simulation) attach(t float64) (float64, int) {
+	var times []float64
+	for _, nd := range s.nodes[s.N:] {
+		if nd.time > t {
+			times = append(times, nd.time)
+		}
+	}
+	sort.Float64s(times)
+
+	for _, next := range append(times, math.Inf(1)) {
+		k := len(s.lineages(t))
+		wait := s.rng.ExpFloat64() / float64(k)
+		if t+wait < next {
+			t += wait
+			break
+		}
+		t = next
+	}
+
+	ls := s.lineages(t)
+	return t, ls[s.rng.Intn(len(ls))]
+}
+
+// tractLength draws a geometric length with mean Delta.
+func (s *simulation) tractLength() int {
+	if s.Delta <= 1 {
+		return 1
+	}
+	p := 1.0 / float64(s.Delta)
+	return 1 + int(math.Floor(math.Log(1-s.rng.Float64())/math.Log(1-p)))
+}
+
+// block returns the index of the block containing site.
+func (s *simulation) block(site int) int {
+	return sort.SearchInts(s.starts, site+1) - 1
+}
+
+func (s *simulation) addRecombinations(ctx context.Context) error {
+	s.top = s.nodes[s.root()].time
+	rate := s.Rho / 2
+	if rate == 0 {
+		return nil
+	}
+
+	for c, nd := range s.nodes {
+		if nd.parent < 0 {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := s.nodes[nd.parent].time
+		for t := nd.time + s.rng.ExpFloat64()/rate; t < end; t += s.rng.ExpFloat64() / rate {
+			start := s.rng.Intn(s.length)
+			b := s.block(start)
+			stop := start + s.tractLength()
+			if blockEnd := s.starts[b] + s.Blocks[b]; stop > blockEnd {
+				stop = blockEnd
+			}
+
+			td, d := s.attach(t)
+			if td > s.top {
+				s.top = td
+			}
+
+			i := len(s.imports)
+			s.imports = append(s.imports, tract{start: start, end: stop, from: td, to: t})
+			s.events = append(s.events, event{time: td, kind: donate, node: d, imp: i})
+			s.events = append(s.events, event{time: t, kind: receive, node: c, imp: i})
+		}
+	}
+	return nil
+}
+
+func (s *simulation) addMutations() {
+	rate := s.Theta / 2
+	if rate == 0 {
+		return
+	}
+
+	for i, nd := range s.nodes {
+		end := s.top
+		if nd.parent >= 0 {
+			end = s.nodes[nd.parent].time
+		}
+		for t := nd.time + s.rng.ExpFloat64()/rate; t < end; t += s.rng.ExpFloat64() / rate {
+			s.events = append(s.events, event{time: t, kind: mutate, node: i, site: s.rng.Intn(s.length)})
+		}
+	}
+}
+
+func (s *simulation) addSplits() {
+	for i, nd := range s.nodes {
+		if nd.parent >= 0 {
+			s.events = append(s.events, event{time: s.nodes[nd.parent].time, kind: split, node: i})
+		}
+	}
+}
+
+// evolve processes the events forward in time, from the top of the root
+// lineage down to the present, and returns the genomes of all nodes.
+// It checks ctx every checkEvents events.
+func (s *simulation) evolve(ctx context.Context) ([][]byte, error) {
+	sort.SliceStable(s.events, func(i, j int) bool {
+		if s.events[i].time != s.events[j].time {
+			return s.events[i].time > s.events[j].time
+		}
+		return s.events[i].kind < s.events[j].kind
+	})
+
+	genomes := make([][]byte, len(s.nodes))
+	root := make([]byte, s.length)
+	for i := range root {
+		root[i] = Bases[s.rng.Intn(len(Bases))]
+	}
+	genomes[s.root()] = root
+
+	for k, e := range s.events {
+		if k%checkEvents == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		switch e.kind {
+		case split:
+			parent := genomes[s.nodes[e.node].parent]
+			genomes[e.node] = append([]byte(nil), parent...)
+		case donate:
+			t := &s.imports[e.imp]
+			t.seq = append([]byte(nil), genomes[e.node][t.start:t.end]...)
+		case receive:
+			t := s.imports[e.imp]
+			s.mutateEdge(t)
+			copy(genomes[e.node][t.start:t.end], t.seq)
+		case mutate:
+			s.mutate(genomes[e.node], e.site)
+		}
+	}
+
+	return genomes[:s.N], nil
+}
+
+// checkEvents is the number of events evolved between checks of the context.
+const checkEvents = 1 << 12
+
+// mutateEdge adds the mutations of a tract on its way
+// along the recombinant edge, from the donor to the recipient.
+func (s *simulation) mutateEdge(t tract) {
+	rate := s.Theta / 2 * float64(t.end-t.start) / float64(s.length)
+	if rate == 0 {
+		return
+	}
+	for x := t.to + s.rng.ExpFloat64()/rate; x < t.from; x += s.rng.ExpFloat64() / rate {
+		s.mutate(t.seq, s.rng.Intn(len(t.seq)))
+	}
+}
+
+// mutate replaces a base by one of the other three at random.
+func (s *simulation) mutate(g []byte, site int) {
+	b := (indexBase(g[site]) + 1 + s.rng.Intn(len(Bases)-1)) % len(Bases)
+	g[site] = Bases[b]
+}
+
+func indexBase(b byte) int {
+	for i := 0; i < len(Bases); i++ {
+		if Bases[i] == b {
+			return i
+		}
+	}
+	return 0
+}
@@ -0,0 +1,113 @@
+package coalescent
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestSimulateShape(t *testing.T) {
+	p := Params{N: 5, Theta: 50, Rho: 50, Delta: 20, Blocks: []int{100, 200, 50}}
+	blocks, err := Simulate(context.Background(), p, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(blocks) != len(p.Blocks) {
+		t.Fatalf("Expect %d blocks, got %d", len(p.Blocks), len(blocks))
+	}
+	for b, block := range blocks {
+		if len(block) != p.N {
+			t.Errorf("Expect %d sequences in block %d, got %d", p.N, b, len(block))
+		}
+		for _, s := range block {
+			if len(s) != p.Blocks[b] {
+				t.Errorf("Expect length %d in block %d, got %d", p.Blocks[b], b, len(s))
+			}
+			for _, c := range s {
+				if bytes.IndexByte([]byte(Bases), c) < 0 {
+					t.Fatalf("Unexpected base %q", c)
+				}
+			}
+		}
+	}
+}
+
+func TestSimulateDeterministic(t *testing.T) {
+	p := Params{N: 8, Theta: 100, Rho: 100, Delta: 50, Blocks: []int{500, 500}}
+	a, _ := Simulate(context.Background(), p, rand.New(rand.NewSource(7)))
+	b, _ := Simulate(context.Background(), p, rand.New(rand.NewSource(7)))
+	for i := range a {
+		for j := range a[i] {
+			if !bytes.Equal(a[i][j], b[i][j]) {
+				t.Fatalf("Sequence %d of block %d differs with the same seed", j, i)
+			}
+		}
+	}
+}
+
+func TestSimulateNoMutation(t *testing.T) {
+	p := Params{N: 6, Theta: 0, Rho: 100, Delta: 10, Blocks: []int{300}}
+	blocks, _ := Simulate(context.Background(), p, rand.New(rand.NewSource(3)))
+	for _, s := range blocks[0][1:] {
+		if !bytes.Equal(s, blocks[0][0]) {
+			t.Fatal("Expect identical sequences without mutation")
+		}
+	}
+}
+
+func TestSimulateDiversity(t *testing.T) {
+	// without recombination, the expected number of pairwise differences
+	// is Theta, less a small Jukes-Cantor correction for repeated hits.
+	p := Params{N: 2, Theta: 20, Rho: 0, Delta: 1, Blocks: []int{10000}}
+	rng := rand.New(rand.NewSource(11))
+	reps := 2000
+	total := 0.0
+	for r := 0; r < reps; r++ {
+		blocks, _ := Simulate(context.Background(), p, rng)
+		for k := range blocks[0][0] {
+			if blocks[0][0][k] != blocks[0][1][k] {
+				total++
+			}
+		}
+	}
+
+	mean := total / float64(reps)
+	if math.Abs(mean-p.Theta) > 0.1*p.Theta {
+		t.Errorf("Expect about %g differences, got %g", p.Theta, mean)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	bad := []Params{
+		{N: 1, Delta: 1, Blocks: []int{1}},
+		{N: 2, Theta: -1, Delta: 1, Blocks: []int{1}},
+		{N: 2, Delta: 0, Blocks: []int{1}},
+		{N: 2, Delta: 1},
+		{N: 2, Delta: 1, Blocks: []int{0}},
+	}
+	for _, p := range bad {
+		if p.Validate() == nil {
+			t.Errorf("Expect an error for %+v", p)
+		}
+	}
+}
+
+func TestSimulateContext(t *testing.T) {
+	p := Params{N: 30, Theta: 5000, Rho: 2000, Delta: 100, Blocks: []int{100000}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Simulate(ctx, p, rand.New(rand.NewSource(1))); err != context.Canceled {
+		t.Errorf("Expect the error of a canceled context, got %v", err)
+	}
+
+	// a simulation stops on its way once its deadline passes.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := Simulate(ctx, p, rand.New(rand.NewSource(1))); err != context.DeadlineExceeded {
+		t.Errorf("Expect the error of a passed deadline, got %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package cov
+
+import (
+	"github.com/mingzhi/biogo/seq"
+	"math/bits"
+)
+
+// Bits is a bit-packed vector, one bit per alignment site,
+// with site k at bit k%64 of word k/64.
+type Bits struct {
+	Len   int
+	Words []uint64
+}
+
+// NewBits returns a vector of n zero bits.
+func NewBits(n int) Bits {
+	return Bits{Len: n, Words: make([]uint64, (n+63)/64)}
+}
+
+// Set sets the bit of site k.
+func (b Bits) Set(k int) {
+	b.Words[k/64] |= 1 << uint(k%64)
+}
+
+// Get returns true if the bit of site k is set.
+func (b Bits) Get(k int) bool {
+	return b.Words[k/64]&(1<<uint(k%64)) != 0
+}
+
+// Count returns the number of set bits.
+func (b Bits) Count() int {
+	n := 0
+	for _, w := range b.Words {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// shifted returns word w of the vector shifted down by l sites,
+// whose bit k is the bit of site k+l.
+func (b Bits) shifted(w, l int) uint64 {
+	q, r := w+l/64, uint(l%64)
+	var x uint64
+	if q < len(b.Words) {
+		x = b.Words[q] >> r
+	}
+	if r > 0 && q+1 < len(b.Words) {
+		x |= b.Words[q+1] << (64 - r)
+	}
+	return x
+}
+
+// PackProfile returns the substitution profile of a pair of aligned
+// sequences as Profile does, packed into bits.
+func (p GapPolicy) PackProfile(a, b []byte, columns []bool) (subs, mask Bits) {
+	subs = NewBits(len(a))
+	mask = NewBits(len(a))
+	for i := 0; i < len(a); i++ {
+		if !columns[i] || !p.Valid(a[i]) || !p.Valid(b[i]) {
+			continue
+		}
+		mask.Set(i)
+		if upper(a[i]) != upper(b[i]) {
+			subs.Set(i)
+		}
+	}
+
+	return
+}
+
+// CovCalculatorBits accumulates lag covariances of bit-packed
+// substitution profiles with popcounts, 64 sites at a time.
+// GetResult agrees with CovCalculator, and GetPooledResult
+// with CovCalculatorFFT, on the same profiles.
+type CovCalculatorBits struct {
+	N    int
+	bias bool
+	// valid pairs of sites, and sums of x, y and xy, at every lag.
+	ns, xs, ys, xys []int64
+}
+
+func NewCovCalculatorBits(maxl int, bias bool) *CovCalculatorBits {
+	var c CovCalculatorBits
+	c.N = maxl
+	c.bias = bias
+	c.ns = make([]int64, maxl)
+	c.xs = make([]int64, maxl)
+	c.ys = make([]int64, maxl)
+	c.xys = make([]int64, maxl)
+	return &c
+}
+
+// Increment adds the pairs of sites of a profile at every lag,
+// skipping pairs with a site not set in mask.
+// Sites of subs must be set only where mask is.
+func (c *CovCalculatorBits) Increment(subs, mask Bits) {
+	for l := 0; l < c.N && l < subs.Len; l++ {
+		var n, x, y, xy int
+		for w := range subs.Words {
+			s, m := subs.Words[w], mask.Words[w]
+			sl, ml := subs.shifted(w, l), mask.shifted(w, l)
+			n += bits.OnesCount64(m & ml)
+			x += bits.OnesCount64(s & ml)
+			y += bits.OnesCount64(sl & m)
+			xy += bits.OnesCount64(s & sl)
+		}
+		c.ns[l] += int64(n)
+		c.xs[l] += int64(x)
+		c.ys[l] += int64(y)
+		c.xys[l] += int64(xy)
+	}
+}
+
+func (c *CovCalculatorBits) Append(c2 *CovCalculatorBits) {
+	for i := 0; i < len(c.ns); i++ {
+		c.ns[i] += c2.ns[i]
+		c.xs[i] += c2.xs[i]
+		c.ys[i] += c2.ys[i]
+		c.xys[i] += c2.xys[i]
+	}
+}
+
+// GetResult returns the covariance at lag i, as CovCalculator.
+func (c *CovCalculatorBits) GetResult(i int) float64 {
+	n := float64(c.ns[i])
+	v := float64(c.xys[i]) - float64(c.xs[i])*float64(c.ys[i])/n
+	if c.bias {
+		return v / (n - 1)
+	}
+	return v / n
+}
+
+// GetPooledResult returns the covariance at lag i, as CovCalculatorFFT,
+// around the mean substitution rate of all valid sites.
+func (c *CovCalculatorBits) GetPooledResult(i int) float64 {
+	pxy := float64(c.xys[i]) / float64(c.ns[i])
+	ks := c.GetKs()
+	return pxy - ks*ks
+}
+
+func (c *CovCalculatorBits) GetMeanXY(i int) float64 {
+	n := float64(c.ns[i])
+	return float64(c.xs[i]) / n * float64(c.ys[i]) / n
+}
+
+func (c *CovCalculatorBits) GetN(i int) int {
+	return int(c.ns[i])
+}
+
+// GetKs returns the mean substitution rate of all valid sites, as CalcKs.
+func (c *CovCalculatorBits) GetKs() float64 {
+	return float64(c.xys[0]) / float64(c.ns[0])
+}
+
+// CalcCtBits calculates Ct of sequences, skipping gaps and ambiguous bases.
+func CalcCtBits(sequences []*seq.Sequence, maxl int) *CovCalculatorBits {
+	return CalcCtBitsMasked(sequences, maxl, SkipGaps)
+}
+
+// CalcCtBitsMasked calculates Ct over the valid sites of every pair,
+// with gaps treated by a policy.
+func CalcCtBitsMasked(sequences []*seq.Sequence, maxl int, policy GapPolicy) *CovCalculatorBits {
+	ct := NewCovCalculatorBits(maxl, false)
+	var seqs [][]byte
+	for _, s := range sequences {
+		seqs = append(seqs, s.Seq)
+	}
+	columns := policy.Columns(seqs)
+	for i := 0; i < len(seqs); i++ {
+		for j := i + 1; j < len(seqs); j++ {
+			ct.Increment(policy.PackProfile(seqs[i], seqs[j], columns))
+		}
+	}
+
+	return ct
+}
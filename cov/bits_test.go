@@ -0,0 +1,83 @@
+package cov
+
+import (
+	"github.com/mingzhi/biogo/seq"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// randomAlignment returns n sequences of a given length,
+// with mutations, gaps and ambiguous bases at the given rates.
+func randomAlignment(n, length int, mutation, gap float64, r *rand.Rand) []*seq.Sequence {
+	ancestor := make([]byte, length)
+	for k := range ancestor {
+		ancestor[k] = "ACGT"[r.Intn(4)]
+	}
+
+	var sequences []*seq.Sequence
+	for i := 0; i < n; i++ {
+		s := make([]byte, length)
+		copy(s, ancestor)
+		for k := range s {
+			switch x := r.Float64(); {
+			case x < gap:
+				s[k] = "-N"[r.Intn(2)]
+			case x < gap+mutation:
+				s[k] = "ACGT"[r.Intn(4)]
+			}
+		}
+		sequences = append(sequences, seq.NewSequence("", s))
+	}
+	return sequences
+}
+
+func TestCalcCtBits(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	sequences := randomAlignment(6, 300, 0.1, 0.02, r)
+	maxl := 130
+
+	for _, policy := range []GapPolicy{SkipGaps, GapState, DropGapColumns} {
+		ct := CalcCtMasked(sequences, maxl, policy)
+		fft := CalcCtFFTMasked(sequences, maxl, policy)
+		ks := CalcKsMasked(sequences, policy)
+		b := CalcCtBitsMasked(sequences, maxl, policy)
+
+		for i := 0; i < maxl; i++ {
+			if ct.GetN(i) != b.GetN(i) || !almostEqual(ct.GetResult(i), b.GetResult(i)) {
+				t.Errorf("%v: Expect Ct(%d) %g of %d pairs, got %g of %d", policy, i, ct.GetResult(i), ct.GetN(i), b.GetResult(i), b.GetN(i))
+			}
+			if !almostEqual(fft.GetResult(i), b.GetPooledResult(i)) {
+				t.Errorf("%v: Expect pooled Ct(%d) %g, got %g", policy, i, fft.GetResult(i), b.GetPooledResult(i))
+			}
+		}
+		if !almostEqual(ks.GetMean(), b.GetKs()) {
+			t.Errorf("%v: Expect Ks %g, got %g", policy, ks.GetMean(), b.GetKs())
+		}
+	}
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-12*math.Max(1, math.Abs(a))
+}
+
+func benchmarkCt(b *testing.B, calc func([]*seq.Sequence, int)) {
+	r := rand.New(rand.NewSource(1))
+	sequences := randomAlignment(10, 10000, 0.01, 0, r)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		calc(sequences, 100)
+	}
+}
+
+func BenchmarkCalcCt(b *testing.B) {
+	benchmarkCt(b, func(s []*seq.Sequence, maxl int) { CalcCt(s, maxl) })
+}
+
+func BenchmarkCalcCtFFT(b *testing.B) {
+	benchmarkCt(b, func(s []*seq.Sequence, maxl int) { CalcCtFFT(s, maxl) })
+}
+
+func BenchmarkCalcCtBits(b *testing.B) {
+	benchmarkCt(b, func(s []*seq.Sequence, maxl int) { CalcCtBits(s, maxl) })
+}
@@ -3,13 +3,16 @@ package cov
 import (
 	"github.com/mingzhi/biogo/seq"
 	"github.com/mingzhi/gomath/stat/correlation"
-	"github.com/mingzhi/gomath/stat/desc"
+	"math"
 )
 
+// CovCalculatorFFT accumulates lag covariances of profiles with FFTs,
+// around the mean of all valid sites.
 type CovCalculatorFFT struct {
 	N            int
 	maskXYs, xys []float64
-	mean         *desc.Mean
+	sites        int64   // valid sites.
+	sum          float64 // sum of the values of valid sites.
 	circular     bool
 }
 
@@ -18,7 +21,6 @@ func NewCovCalculatorFFT(maxl int, circular bool) *CovCalculatorFFT {
 	c.N = maxl
 	c.maskXYs = make([]float64, c.N)
 	c.xys = make([]float64, c.N)
-	c.mean = desc.NewMean()
 	c.circular = circular
 
 	return &c
@@ -28,20 +30,43 @@ func (c *CovCalculatorFFT) Increment(xs []float64) {
 	masks := make([]float64, len(xs))
 	for i := 0; i < len(masks); i++ {
 		masks[i] = 1.0
-		c.mean.Increment(xs[i])
+	}
+	c.IncrementMasked(xs, masks)
+}
+
+// IncrementMasked adds a profile whose sites are valid where masks is 1;
+// values at masked sites are ignored.
+// Products of profiles of zeros and ones are counts,
+// so the round-off of FFTs is removed from them.
+func (c *CovCalculatorFFT) IncrementMasked(xs, masks []float64) {
+	binary := true
+	masked := make([]float64, len(xs))
+	for i := 0; i < len(xs); i++ {
+		if masks[i] > 0 {
+			masked[i] = xs[i]
+			c.sites++
+			c.sum += xs[i]
+			binary = binary && (xs[i] == 0 || xs[i] == 1)
+		}
 	}
 
 	maskXYs := correlation.AutoCorrFFT(masks, c.circular)
-	xys := correlation.AutoCorrFFT(xs, c.circular)
+	xys := correlation.AutoCorrFFT(masked, c.circular)
 
 	for i := 0; i < len(c.xys); i++ {
-		c.xys[i] += (xys[i] + xys[(len(xys)-i)%len(xys)])
-		c.maskXYs[i] += (maskXYs[i] + maskXYs[(len(maskXYs)-i)%len(maskXYs)])
+		xy := xys[i] + xys[(len(xys)-i)%len(xys)]
+		maskXY := maskXYs[i] + maskXYs[(len(maskXYs)-i)%len(maskXYs)]
+		if binary {
+			xy = math.Floor(xy + 0.5)
+		}
+		c.xys[i] += xy
+		c.maskXYs[i] += math.Floor(maskXY + 0.5)
 	}
 }
 
 func (c *CovCalculatorFFT) Append(c2 *CovCalculatorFFT) {
-	c.mean.Append(c2.mean)
+	c.sites += c2.sites
+	c.sum += c2.sum
 	for i := 0; i < len(c2.xys); i++ {
 		c.xys[i] += c2.xys[i]
 		c.maskXYs[i] += c2.maskXYs[i]
@@ -50,73 +75,102 @@ func (c *CovCalculatorFFT) Append(c2 *CovCalculatorFFT) {
 
 func (c *CovCalculatorFFT) GetResult(i int) float64 {
 	pxy := c.xys[i] / c.maskXYs[i]
-	pxpy := c.mean.GetResult() * c.mean.GetResult()
-	return pxy - pxpy
+	mean := c.sum / float64(c.sites)
+	return pxy - mean*mean
 }
 
+// CovCalculator accumulates the covariance of pairs of sites at every lag.
 type CovCalculator struct {
-	N     int
-	corrs []*correlation.BivariateCovariance
+	N      int
+	bias   bool
+	ns     []int64
+	xs, ys []float64
+	xys    []float64
 }
 
 func NewCovCalculator(maxl int, bias bool) *CovCalculator {
 	cc := CovCalculator{}
-	cc.corrs = make([]*correlation.BivariateCovariance, maxl)
-	for i := 0; i < maxl; i++ {
-		cc.corrs[i] = correlation.NewBivariateCovariance(bias)
-	}
 	cc.N = maxl
+	cc.bias = bias
+	cc.ns = make([]int64, maxl)
+	cc.xs = make([]float64, maxl)
+	cc.ys = make([]float64, maxl)
+	cc.xys = make([]float64, maxl)
 	return &cc
 }
 
 func (cc *CovCalculator) Increment(i int, x, y float64) {
-	cc.corrs[i].Increment(x, y)
+	cc.ns[i]++
+	cc.xs[i] += x
+	cc.ys[i] += y
+	cc.xys[i] += x * y
+}
+
+// IncrementMasked adds the pairs of sites of a profile at every lag,
+// skipping pairs with a site not valid in masks.
+func (cc *CovCalculator) IncrementMasked(xs, masks []float64) {
+	for l := 0; l < cc.N; l++ {
+		for k := 0; k < len(xs)-l; k++ {
+			if masks[k] > 0 && masks[k+l] > 0 {
+				cc.Increment(l, xs[k], xs[k+l])
+			}
+		}
+	}
 }
 
+// GetResult returns the covariance at lag i,
+// divided by the number of pairs less one if bias is corrected.
 func (cc *CovCalculator) GetResult(i int) float64 {
-	return cc.corrs[i].GetResult()
+	n := float64(cc.ns[i])
+	v := cc.xys[i] - cc.xs[i]*cc.ys[i]/n
+	if cc.bias {
+		return v / (n - 1)
+	}
+	return v / n
 }
 
 func (cc *CovCalculator) GetMeanXY(i int) float64 {
-	return cc.corrs[i].MeanX() * cc.corrs[i].MeanY()
+	n := float64(cc.ns[i])
+	return cc.xs[i] / n * cc.ys[i] / n
 }
 
 func (cc *CovCalculator) GetN(i int) int {
-	return cc.corrs[i].GetN()
+	return int(cc.ns[i])
 }
 
 func (cc *CovCalculator) Append(cc2 *CovCalculator) {
-	for i := 0; i < len(cc.corrs); i++ {
-		cc.corrs[i].Append(cc2.corrs[i])
+	for i := 0; i < len(cc.ns); i++ {
+		cc.ns[i] += cc2.ns[i]
+		cc.xs[i] += cc2.xs[i]
+		cc.ys[i] += cc2.ys[i]
+		cc.xys[i] += cc2.xys[i]
 	}
 }
 
+// CalcCtFFT calculates Ct of sequences, skipping gaps and ambiguous bases.
 func CalcCtFFT(sequences []*seq.Sequence, maxl int) *CovCalculatorFFT {
+	return CalcCtFFTMasked(sequences, maxl, SkipGaps)
+}
+
+// CalcCtFFTMasked calculates Ct over the valid sites of every pair,
+// with gaps treated by a policy.
+func CalcCtFFTMasked(sequences []*seq.Sequence, maxl int, policy GapPolicy) *CovCalculatorFFT {
 	ct := NewCovCalculatorFFT(maxl, false)
-	for i := 0; i < len(sequences); i++ {
-		for j := i + 1; j < len(sequences); j++ {
-			subs := subProfile(sequences[i], sequences[j])
-			ct.Increment(subs)
-		}
-	}
+	eachProfile(sequences, policy, ct.IncrementMasked)
 
 	return ct
 }
 
+// CalcCt calculates Ct of sequences, skipping gaps and ambiguous bases.
 func CalcCt(sequences []*seq.Sequence, maxl int) *CovCalculator {
+	return CalcCtMasked(sequences, maxl, SkipGaps)
+}
+
+// CalcCtMasked calculates Ct over the valid sites of every pair,
+// with gaps treated by a policy.
+func CalcCtMasked(sequences []*seq.Sequence, maxl int, policy GapPolicy) *CovCalculator {
 	ct := NewCovCalculator(maxl, false)
-	for i := 0; i < len(sequences); i++ {
-		for j := i + 1; j < len(sequences); j++ {
-			subs := subProfile(sequences[i], sequences[j])
-
-			for l := 0; l < maxl; l++ {
-				for k := 0; k < len(subs)-l; k++ {
-					x, y := subs[k], subs[k+l]
-					ct.Increment(l, x, y)
-				}
-			}
-		}
-	}
+	eachProfile(sequences, policy, ct.IncrementMasked)
 
 	return ct
 }
@@ -2,33 +2,62 @@ package cov
 
 import (
 	"github.com/mingzhi/biogo/seq"
-	"github.com/mingzhi/gomath/stat/desc/meanvar"
 )
 
+// KsCalculator accumulates the mean and variance of substitutions at sites
+// in exact sums, which its state saves.
 type KsCalculator struct {
-	*meanvar.MeanVar
+	n          int64
+	sum, sumSq float64
+}
+
+func NewKsCalculator() *KsCalculator {
+	return &KsCalculator{}
+}
+
+func (k *KsCalculator) Increment(x float64) {
+	k.n++
+	k.sum += x
+	k.sumSq += x * x
 }
 
 func (k *KsCalculator) Append(k2 *KsCalculator) {
-	k.MeanVar.Append(k2.MeanVar)
+	k.n += k2.n
+	k.sum += k2.sum
+	k.sumSq += k2.sumSq
 }
 
-func NewKsCalculator() *KsCalculator {
-	kc := KsCalculator{}
-	kc.MeanVar = meanvar.New()
-	return &kc
+// GetMean returns Ks.
+func (k *KsCalculator) GetMean() float64 {
+	return k.sum / float64(k.n)
 }
 
+// GetVariance returns the unbiased variance of substitutions at sites.
+func (k *KsCalculator) GetVariance() float64 {
+	n := float64(k.n)
+	return (k.sumSq - k.sum*k.sum/n) / (n - 1)
+}
+
+func (k *KsCalculator) GetN() int {
+	return int(k.n)
+}
+
+// CalcKs calculates Ks of sequences, skipping gaps and ambiguous bases.
 func CalcKs(sequences []*seq.Sequence) *KsCalculator {
+	return CalcKsMasked(sequences, SkipGaps)
+}
+
+// CalcKsMasked calculates Ks over the valid sites of every pair,
+// with gaps treated by a policy.
+func CalcKsMasked(sequences []*seq.Sequence, policy GapPolicy) *KsCalculator {
 	ks := NewKsCalculator()
-	for i := 0; i < len(sequences); i++ {
-		for j := i + 1; j < len(sequences); j++ {
-			subs := subProfile(sequences[i], sequences[j])
-			for k := 0; k < len(subs); k++ {
+	eachProfile(sequences, policy, func(subs, mask []float64) {
+		for k := 0; k < len(subs); k++ {
+			if mask[k] > 0 {
 				ks.Increment(subs[k])
 			}
 		}
-	}
+	})
 
 	return ks
 }
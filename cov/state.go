@@ -0,0 +1,156 @@
+package cov
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// StateVersion is the version of the JSON state of calculators.
+// States of other versions are rejected when read.
+//
+// A state holds the raw sums and counts of a calculator, so that
+// calculators saved by separate runs and appended after reading
+// give the results of a single run. With profiles of zeros and ones,
+// as substitution profiles are, all sums are counts and are exact
+// in any order of appending.
+const StateVersion = 1
+
+type header struct {
+	Version int
+	Kind    string
+}
+
+func checkHeader(data []byte, kind string) error {
+	var h header
+	if err := json.Unmarshal(data, &h); err != nil {
+		return err
+	}
+	if h.Kind != kind {
+		return fmt.Errorf("cov: state of %s, expect %s", h.Kind, kind)
+	}
+	if h.Version != StateVersion {
+		return fmt.Errorf("cov: %s state version %d, expect %d", kind, h.Version, StateVersion)
+	}
+	return nil
+}
+
+// checkLengths checks that the slices of a state have lengths of n lags.
+func checkLengths(kind string, n int, lengths ...int) error {
+	for _, l := range lengths {
+		if l != n {
+			return fmt.Errorf("cov: %s state of %d and %d lags", kind, n, l)
+		}
+	}
+	return nil
+}
+
+type covState struct {
+	header
+	Bias     bool
+	Pairs    []int64 // pairs of sites at every lag.
+	X, Y, XY []float64
+}
+
+func (cc *CovCalculator) MarshalJSON() ([]byte, error) {
+	return json.Marshal(covState{
+		header{StateVersion, "CovCalculator"},
+		cc.bias, cc.ns, cc.xs, cc.ys, cc.xys,
+	})
+}
+
+func (cc *CovCalculator) UnmarshalJSON(data []byte) error {
+	if err := checkHeader(data, "CovCalculator"); err != nil {
+		return err
+	}
+	var s covState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if err := checkLengths("CovCalculator", len(s.Pairs), len(s.X), len(s.Y), len(s.XY)); err != nil {
+		return err
+	}
+	*cc = CovCalculator{N: len(s.Pairs), bias: s.Bias, ns: s.Pairs, xs: s.X, ys: s.Y, xys: s.XY}
+	return nil
+}
+
+type covFFTState struct {
+	header
+	Circular bool
+	Sites    int64
+	Sum      float64
+	XY       []float64
+	MaskXY   []float64
+}
+
+func (c *CovCalculatorFFT) MarshalJSON() ([]byte, error) {
+	return json.Marshal(covFFTState{
+		header{StateVersion, "CovCalculatorFFT"},
+		c.circular, c.sites, c.sum, c.xys, c.maskXYs,
+	})
+}
+
+func (c *CovCalculatorFFT) UnmarshalJSON(data []byte) error {
+	if err := checkHeader(data, "CovCalculatorFFT"); err != nil {
+		return err
+	}
+	var s covFFTState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if err := checkLengths("CovCalculatorFFT", len(s.XY), len(s.MaskXY)); err != nil {
+		return err
+	}
+	*c = CovCalculatorFFT{N: len(s.XY), maskXYs: s.MaskXY, xys: s.XY, sites: s.Sites, sum: s.Sum, circular: s.Circular}
+	return nil
+}
+
+type covBitsState struct {
+	header
+	Bias     bool
+	Pairs    []int64
+	X, Y, XY []int64
+}
+
+func (c *CovCalculatorBits) MarshalJSON() ([]byte, error) {
+	return json.Marshal(covBitsState{
+		header{StateVersion, "CovCalculatorBits"},
+		c.bias, c.ns, c.xs, c.ys, c.xys,
+	})
+}
+
+func (c *CovCalculatorBits) UnmarshalJSON(data []byte) error {
+	if err := checkHeader(data, "CovCalculatorBits"); err != nil {
+		return err
+	}
+	var s covBitsState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if err := checkLengths("CovCalculatorBits", len(s.Pairs), len(s.X), len(s.Y), len(s.XY)); err != nil {
+		return err
+	}
+	*c = CovCalculatorBits{N: len(s.Pairs), bias: s.Bias, ns: s.Pairs, xs: s.X, ys: s.Y, xys: s.XY}
+	return nil
+}
+
+type ksState struct {
+	header
+	N          int64
+	Sum, SumSq float64
+}
+
+func (k *KsCalculator) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ksState{header{StateVersion, "KsCalculator"}, k.n, k.sum, k.sumSq})
+}
+
+func (k *KsCalculator) UnmarshalJSON(data []byte) error {
+	if err := checkHeader(data, "KsCalculator"); err != nil {
+		return err
+	}
+	var s ksState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*k = KsCalculator{n: s.N, sum: s.Sum, sumSq: s.SumSq}
+	return nil
+}
@@ -0,0 +1,108 @@
+package cov
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestStateMerge(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	maxl := 50
+	whole := NewCalculators(maxl, false)
+	wholeFFT := NewCalculatorsFFT(maxl, false)
+	var parts, partsFFT []string
+	for i := 0; i < 4; i++ {
+		sequences := randomAlignment(5, 200, 0.05, 0.01, r)
+		c := &Calculators{Ks: CalcKs(sequences), Ct: CalcCt(sequences, maxl)}
+		cf := &CalculatorsFFT{Ks: CalcKs(sequences), Ct: CalcCtFFT(sequences, maxl)}
+		whole.Append(c)
+		wholeFFT.Append(cf)
+
+		data, err := json.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, string(data))
+		data, err = json.Marshal(cf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		partsFFT = append(partsFFT, string(data))
+	}
+
+	// merge the saved states in reverse order.
+	merged := NewCalculators(maxl, false)
+	mergedFFT := NewCalculatorsFFT(maxl, false)
+	for i := len(parts) - 1; i >= 0; i-- {
+		var c Calculators
+		if err := json.Unmarshal([]byte(parts[i]), &c); err != nil {
+			t.Fatal(err)
+		}
+		merged.Append(&c)
+		var cf CalculatorsFFT
+		if err := json.Unmarshal([]byte(partsFFT[i]), &cf); err != nil {
+			t.Fatal(err)
+		}
+		mergedFFT.Append(&cf)
+	}
+
+	if merged.Ks.GetMean() != whole.Ks.GetMean() || merged.Ks.GetVariance() != whole.Ks.GetVariance() {
+		t.Errorf("Expect Ks %g, got %g", whole.Ks.GetMean(), merged.Ks.GetMean())
+	}
+	for i := 0; i < maxl; i++ {
+		if merged.Ct.GetResult(i) != whole.Ct.GetResult(i) {
+			t.Errorf("Expect Ct(%d) %g, got %g", i, whole.Ct.GetResult(i), merged.Ct.GetResult(i))
+		}
+		if mergedFFT.Ct.GetResult(i) != wholeFFT.Ct.GetResult(i) {
+			t.Errorf("Expect FFT Ct(%d) %g, got %g", i, wholeFFT.Ct.GetResult(i), mergedFFT.Ct.GetResult(i))
+		}
+	}
+}
+
+func TestKsState(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	ks := CalcKs(randomAlignment(5, 200, 0.05, 0.01, r))
+	data, err := json.Marshal(ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read KsCalculator
+	if err := json.Unmarshal(data, &read); err != nil {
+		t.Fatal(err)
+	}
+	if read.GetN() != ks.GetN() || read.GetMean() != ks.GetMean() || read.GetVariance() != ks.GetVariance() {
+		t.Errorf("Expect Ks %g and variance %g of %d sites, got %g and %g of %d", ks.GetMean(),
+			ks.GetVariance(), ks.GetN(), read.GetMean(), read.GetVariance(), read.GetN())
+	}
+}
+
+func TestStateVersion(t *testing.T) {
+	data, err := json.Marshal(NewKsCalculator())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := strings.Replace(string(data), `"Version":1`, `"Version":0`, 1)
+	var ks KsCalculator
+	if err := json.Unmarshal([]byte(old), &ks); err == nil {
+		t.Errorf("Expect an error for state %s", old)
+	}
+	var ct CovCalculator
+	if err := json.Unmarshal(data, &ct); err == nil {
+		t.Errorf("Expect an error for reading a KsCalculator state as a CovCalculator")
+	}
+}
+
+func TestStateLengths(t *testing.T) {
+	states := map[string]interface{}{
+		`{"Version":1,"Kind":"CovCalculator","Pairs":[1,2],"X":[0],"Y":[0,0],"XY":[0,0]}`:         &CovCalculator{},
+		`{"Version":1,"Kind":"CovCalculatorBits","Pairs":[1,2],"X":[0,0],"Y":[0,0],"XY":[0,0,0]}`: &CovCalculatorBits{},
+		`{"Version":1,"Kind":"CovCalculatorFFT","XY":[0,0],"MaskXY":[0]}`:                         &CovCalculatorFFT{},
+	}
+	for state, c := range states {
+		if err := json.Unmarshal([]byte(state), c); err == nil {
+			t.Errorf("Expect an error for state %s of slices of different lengths", state)
+		}
+	}
+}
@@ -1,18 +1,112 @@
 package cov
 
 import (
+	"fmt"
 	"github.com/mingzhi/biogo/seq"
 )
 
-func subProfile(a, b *seq.Sequence) []float64 {
-	var subs []float64
-	for i := 0; i < len(a.Seq); i++ {
-		var v float64 = 0
-		if a.Seq[i] != b.Seq[i] {
-			v = 1.0
+// GapPolicy decides how gaps take part in substitution profiles.
+// Ambiguous bases, such as N and other IUPAC codes, never do.
+type GapPolicy int
+
+const (
+	// SkipGaps masks the sites where either sequence of a pair has a gap.
+	SkipGaps GapPolicy = iota
+	// GapState treats a gap as a fifth nucleotide.
+	GapState
+	// DropGapColumns masks the alignment columns where any sequence has a gap.
+	DropGapColumns
+)
+
+var gapPolicyNames = []string{"skip", "state", "drop"}
+
+func (p GapPolicy) String() string {
+	if p < 0 || int(p) >= len(gapPolicyNames) {
+		return fmt.Sprintf("GapPolicy(%d)", int(p))
+	}
+	return gapPolicyNames[p]
+}
+
+// ParseGapPolicy returns the policy named skip, state or drop.
+func ParseGapPolicy(name string) (GapPolicy, error) {
+	for i, n := range gapPolicyNames {
+		if n == name {
+			return GapPolicy(i), nil
+		}
+	}
+	return SkipGaps, fmt.Errorf("unknown gap policy %q, expect skip, state or drop", name)
+}
+
+// Valid returns true if a base takes part in comparisons:
+// A, C, G and T in either case, and gaps under GapState.
+func (p GapPolicy) Valid(b byte) bool {
+	switch b {
+	case 'A', 'C', 'G', 'T', 'a', 'c', 'g', 't':
+		return true
+	case '-':
+		return p == GapState
+	}
+	return false
+}
+
+// Columns returns the alignment columns kept for every pair,
+// which are all columns except, under DropGapColumns, those with a gap.
+func (p GapPolicy) Columns(sequences [][]byte) []bool {
+	if len(sequences) == 0 {
+		return nil
+	}
+	columns := make([]bool, len(sequences[0]))
+	for k := range columns {
+		columns[k] = true
+		if p == DropGapColumns {
+			for _, s := range sequences {
+				if s[k] == '-' {
+					columns[k] = false
+					break
+				}
+			}
+		}
+	}
+	return columns
+}
+
+// Profile returns the substitution profile of a pair of aligned sequences:
+// subs is 1 where they differ, and mask is 1 where the comparison is valid,
+// that is, where the column is kept and both bases are valid.
+// Masked sites have no substitution.
+func (p GapPolicy) Profile(a, b []byte, columns []bool) (subs, mask []float64) {
+	subs = make([]float64, len(a))
+	mask = make([]float64, len(a))
+	for i := 0; i < len(a); i++ {
+		if !columns[i] || !p.Valid(a[i]) || !p.Valid(b[i]) {
+			continue
+		}
+		mask[i] = 1.0
+		if upper(a[i]) != upper(b[i]) {
+			subs[i] = 1.0
+		}
+	}
+
+	return
+}
+
+// eachProfile calls fn with the substitution profile of every pair of sequences.
+func eachProfile(sequences []*seq.Sequence, p GapPolicy, fn func(subs, mask []float64)) {
+	var seqs [][]byte
+	for _, s := range sequences {
+		seqs = append(seqs, s.Seq)
+	}
+	columns := p.Columns(seqs)
+	for i := 0; i < len(seqs); i++ {
+		for j := i + 1; j < len(seqs); j++ {
+			fn(p.Profile(seqs[i], seqs[j], columns))
 		}
-		subs = append(subs, v)
 	}
+}
 
-	return subs
+func upper(b byte) byte {
+	if b >= 'a' && b <= 'z' {
+		return b - 'a' + 'A'
+	}
+	return b
 }
@@ -0,0 +1,37 @@
+package cov
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	seqs := [][]byte{
+		[]byte("ACGT-NAc"),
+		[]byte("ATG-AAAC"),
+		[]byte("ACGTTAAC"),
+	}
+	tests := []struct {
+		policy     GapPolicy
+		subs, mask string
+	}{
+		{SkipGaps, "[0 1 0 0 0 0 0 0]", "[1 1 1 0 0 0 1 1]"},
+		{GapState, "[0 1 0 1 1 0 0 0]", "[1 1 1 1 1 0 1 1]"},
+		{DropGapColumns, "[0 1 0 0 0 0 0 0]", "[1 1 1 0 0 0 1 1]"},
+	}
+
+	for _, test := range tests {
+		columns := test.policy.Columns(seqs)
+		subs, mask := test.policy.Profile(seqs[0], seqs[1], columns)
+		if fmt.Sprint(subs) != test.subs || fmt.Sprint(mask) != test.mask {
+			t.Errorf("%v: Expect %s %s, got %v %v", test.policy, test.subs, test.mask, subs, mask)
+		}
+	}
+
+	// the gap of the first sequence drops its column from every pair.
+	columns := DropGapColumns.Columns(seqs)
+	_, mask := DropGapColumns.Profile(seqs[1], seqs[2], columns)
+	if expected := "[1 1 1 0 0 1 1 1]"; fmt.Sprint(mask) != expected {
+		t.Errorf("Expect %s, got %v", expected, mask)
+	}
+}
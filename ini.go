@@ -0,0 +1,264 @@
+package simmlst
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// WriteINI writes a config in the INI format, one "key = value" per line.
+// Every field is written, so that ReadINI returns the same config;
+// loci with commas, which separate them, or with leading or trailing
+// spaces, which are dropped, are an error.
+func WriteINI(w io.Writer, p Config) error {
+	for _, name := range p.Loci {
+		if strings.Contains(name, ",") {
+			return fmt.Errorf("ini: locus %q has a comma", name)
+		}
+		if name != strings.TrimSpace(name) {
+			return fmt.Errorf("ini: locus %q has leading or trailing spaces", name)
+		}
+	}
+
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "theta = %s\n", formatFloat(p.Theta))
+	fmt.Fprintf(&b, "rho = %s\n", formatFloat(p.Rho))
+	fmt.Fprintf(&b, "n = %d\n", p.N)
+	fmt.Fprintf(&b, "delta = %d\n", p.Delta)
+	fmt.Fprintf(&b, "num_gene = %d\n", p.NumGene)
+	fmt.Fprintf(&b, "len_gene = %d\n", p.LenGene)
+	fmt.Fprintf(&b, "blocks = %s\n", joinInts(p.Blocks))
+	fmt.Fprintf(&b, "loci = %s\n", strings.Join(p.Loci, ","))
+	fmt.Fprintf(&b, "output = %s\n", p.Output)
+	fmt.Fprintf(&b, "seed = %d\n", p.Seed)
+	fmt.Fprintf(&b, "per_site = %t\n", p.PerSite)
+	fmt.Fprintf(&b, "clonal_tree = %s\n", p.ClonalTree)
+	fmt.Fprintf(&b, "local_trees = %s\n", p.LocalTrees)
+	fmt.Fprintf(&b, "graph = %s\n", p.Graph)
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// WriteINIs writes configs in the INI format, each in a [config] section.
+func WriteINIs(w io.Writer, ps []Config) error {
+	for i, p := range ps {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(w, "[config]\n"); err != nil {
+			return err
+		}
+		if err := WriteINI(w, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadINI reads a config in the INI format.
+func ReadINI(r io.Reader) (Config, error) {
+	ps, err := ReadINIs(r)
+	if err != nil {
+		return Config{}, err
+	}
+	if len(ps) != 1 {
+		return Config{}, fmt.Errorf("ini: %d configs, expect one", len(ps))
+	}
+	return ps[0], nil
+}
+
+// ReadINIs reads configs in the INI format.
+// Each section header, such as [config], starts a new config;
+// a file without headers holds one config.
+// Blank lines and lines starting with # or ; are ignored.
+func ReadINIs(r io.Reader) ([]Config, error) {
+	var ps []Config
+	var p *Config
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+
+		if line[0] == '[' {
+			ps = append(ps, Config{})
+			p = &ps[len(ps)-1]
+			continue
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("ini: line %d: missing '='", lineNum)
+		}
+		if p == nil {
+			ps = append(ps, Config{})
+			p = &ps[len(ps)-1]
+		}
+
+		key := strings.TrimSpace(line[:eq])
+		value := strings.TrimSpace(line[eq+1:])
+		if err := p.set(key, value); err != nil {
+			return nil, fmt.Errorf("ini: line %d: %s: %v", lineNum, key, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return ps, nil
+}
+
+// set sets the field of an INI key.
+func (p *Config) set(key, value string) (err error) {
+	switch key {
+	case "theta":
+		p.Theta, err = strconv.ParseFloat(value, 64)
+	case "rho":
+		p.Rho, err = strconv.ParseFloat(value, 64)
+	case "n":
+		p.N, err = strconv.Atoi(value)
+	case "delta":
+		p.Delta, err = strconv.Atoi(value)
+	case "num_gene":
+		p.NumGene, err = strconv.Atoi(value)
+	case "len_gene":
+		p.LenGene, err = strconv.Atoi(value)
+	case "blocks":
+		p.Blocks, err = splitInts(value)
+	case "loci":
+		p.Loci = nil
+		if value != "" {
+			p.Loci = strings.Split(value, ",")
+		}
+	case "output":
+		p.Output = value
+	case "seed":
+		p.Seed, err = strconv.ParseInt(value, 10, 64)
+	case "per_site":
+		p.PerSite, err = strconv.ParseBool(value)
+	case "clonal_tree":
+		p.ClonalTree = value
+	case "local_trees":
+		p.LocalTrees = value
+	case "graph":
+		p.Graph = value
+	default:
+		err = fmt.Errorf("unknown key")
+	}
+	return
+}
+
+// ReadConfigs reads configs from a .json or .ini file.
+// A JSON file holds either one config or an array of configs.
+func ReadConfigs(filename string) ([]Config, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ps []Config
+	switch filepath.Ext(filename) {
+	case ".ini":
+		ps, err = ReadINIs(f)
+	case ".json":
+		ps, err = readJSONConfigs(f)
+	default:
+		err = fmt.Errorf("unknown config format")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return ps, nil
+}
+
+// ReadConfig reads one config from a .json or .ini file.
+func ReadConfig(filename string) (Config, error) {
+	ps, err := ReadConfigs(filename)
+	if err != nil {
+		return Config{}, err
+	}
+	if len(ps) != 1 {
+		return Config{}, fmt.Errorf("%s: %d configs, expect one", filename, len(ps))
+	}
+	return ps[0], nil
+}
+
+// WriteConfigs writes configs to a .json or .ini file.
+func WriteConfigs(filename string, ps []Config) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch filepath.Ext(filename) {
+	case ".ini":
+		if len(ps) == 1 {
+			return WriteINI(f, ps[0])
+		}
+		return WriteINIs(f, ps)
+	case ".json":
+		if len(ps) == 1 {
+			return json.NewEncoder(f).Encode(ps[0])
+		}
+		return json.NewEncoder(f).Encode(ps)
+	}
+	return fmt.Errorf("%s: unknown config format", filename)
+}
+
+func readJSONConfigs(r io.Reader) ([]Config, error) {
+	br := bufio.NewReader(r)
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			continue
+		}
+		br.UnreadByte()
+
+		var ps []Config
+		d := json.NewDecoder(br)
+		if c == '[' {
+			err = d.Decode(&ps)
+		} else {
+			ps = make([]Config, 1)
+			err = d.Decode(&ps[0])
+		}
+		return ps, err
+	}
+}
+
+func splitInts(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	var values []int
+	for _, f := range strings.Split(s, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// formatFloat formats f with the fewest digits that read back exactly.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
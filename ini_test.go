@@ -0,0 +1,87 @@
+package simmlst
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestINIRoundTrip(t *testing.T) {
+	configs := []Config{
+		{Theta: 1000, Rho: 250.5, N: 10, Delta: 50, NumGene: 7, LenGene: 400, Output: "test_individual_0"},
+		{
+			Theta: 0.0123456789, Rho: 1e-7, N: 3, Delta: 1, Blocks: []int{400, 123, 9},
+			Loci: []string{"adk", "fumC", "gyrB"}, Output: "out", Seed: 42, PerSite: true,
+			ClonalTree: "a.nwk", LocalTrees: "b.nwk", Graph: "c.dot",
+		},
+	}
+
+	for _, c := range configs {
+		var b bytes.Buffer
+		if err := WriteINI(&b, c); err != nil {
+			t.Fatal(err)
+		}
+		c2, err := ReadINI(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(c, c2) {
+			t.Errorf("Expect %+v, got %+v", c, c2)
+		}
+	}
+
+	var b bytes.Buffer
+	if err := WriteINIs(&b, configs); err != nil {
+		t.Fatal(err)
+	}
+	configs2, err := ReadINIs(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(configs, configs2) {
+		t.Errorf("Expect %+v, got %+v", configs, configs2)
+	}
+}
+
+func TestReadINIErrors(t *testing.T) {
+	inputs := []string{
+		"theta = 1\nfoo = 2\n",
+		"theta = 1\nn 2\n",
+		"n = two\n",
+	}
+	for _, in := range inputs {
+		if _, err := ReadINI(strings.NewReader(in)); err == nil {
+			t.Errorf("Expect an error for %q", in)
+		}
+	}
+}
+
+func TestLociCommas(t *testing.T) {
+	c := Config{Theta: 1, Rho: 1, N: 3, Delta: 1, Blocks: []int{10, 10}, Loci: []string{"adk", "fumC,gyrB"}}
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "comma") {
+		t.Errorf("Expect an error of a locus with a comma, got %v", err)
+	}
+	if err := WriteINI(&bytes.Buffer{}, c); err == nil {
+		t.Errorf("Expect an error of writing a locus with a comma")
+	}
+	if s := c.String(); !strings.Contains(s, "comma") {
+		t.Errorf("Expect the error of a locus with a comma as the config, got %q", s)
+	}
+}
+
+func TestLociSpaces(t *testing.T) {
+	for _, name := range []string{" adk", "adk ", "\tadk"} {
+		c := Config{Theta: 1, Rho: 1, N: 3, Delta: 1, Blocks: []int{10, 10}, Loci: []string{name, "fumC"}}
+		if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "spaces") {
+			t.Errorf("Expect an error of locus %q, got %v", name, err)
+		}
+		if err := WriteINI(&bytes.Buffer{}, c); err == nil {
+			t.Errorf("Expect an error of writing locus %q", name)
+		}
+	}
+	c := Config{Theta: 1, Rho: 1, N: 3, Delta: 1, Blocks: []int{10, 10}, Loci: []string{"adk gene", "fumC"}}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Expect a locus with inner spaces, got %v", err)
+	}
+}
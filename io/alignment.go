@@ -0,0 +1,64 @@
+package io
+
+import (
+	"fmt"
+	"github.com/mingzhi/biogo/seq"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FastaExts lists the extensions of the FASTA files
+// read from a directory by ReadAlignment.
+var FastaExts = []string{".fasta", ".fas", ".fa", ".fna", ".aln"}
+
+// ReadAlignment reads the alignment blocks of an existing alignment:
+// a XMFA file, a multi-FASTA file, which is a single block,
+// or a directory of per-gene FASTA alignments, one block per file
+// in the order of their names.
+func ReadAlignment(path string) ([][]*seq.Sequence, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		// a FASTA file is a XMFA file of a single block.
+		return ReadXMFA(path)
+	}
+
+	var files []string
+	for _, ext := range FastaExts {
+		matches, err := filepath.Glob(filepath.Join(path, "*"+ext))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s: no FASTA files (%s)", path, strings.Join(FastaExts, ", "))
+	}
+	sort.Strings(files)
+
+	var blocks [][]*seq.Sequence
+	for _, file := range files {
+		block, err := ReadFasta(file)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// ReadFasta reads an aligned multi-FASTA file.
+func ReadFasta(filename string) ([]*seq.Sequence, error) {
+	blocks, err := ReadXMFA(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks) != 1 {
+		return nil, fmt.Errorf("%s: expect one alignment, got %d", filename, len(blocks))
+	}
+	return blocks[0], nil
+}
@@ -0,0 +1,35 @@
+package io
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadAlignmentDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alignment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"b.fasta":   ">s1\nAC\n>s2\nAT\n",
+		"a.fa":      ">s1\nGGG\n>s2\nGGA\n",
+		"notes.txt": "not an alignment",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	blocks, err := ReadAlignment(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || string(blocks[0][1].Seq) != "GGA" || string(blocks[1][1].Seq) != "AT" {
+		t.Errorf("Expect blocks of a.fa and b.fasta, got %v", blocks)
+	}
+}
@@ -0,0 +1,169 @@
+package io
+
+import (
+	"fmt"
+	"github.com/mingzhi/biogo/seq"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Entry is a sequence of an alignment block,
+// with the fields of its XMFA header "> seq:start-end strand comment".
+type Entry struct {
+	SeqIndex   int    // 1-based index of the source sequence.
+	Start, End int    // 1-based inclusive coordinates, zero if the sequence is absent.
+	Strand     byte   // '+' or '-'.
+	Comment    string // anything after the strand, such as a name.
+	Seq        *seq.Sequence
+}
+
+// Block is an alignment block.
+type Block struct {
+	Entries []Entry
+}
+
+// ParseHeader parses a XMFA header, with or without the leading '>'.
+func ParseHeader(header string) (Entry, error) {
+	var e Entry
+	fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(header), ">"))
+	if len(fields) < 2 {
+		return e, fmt.Errorf("xmfa header %q: expect seq:start-end strand", header)
+	}
+
+	colon := strings.IndexByte(fields[0], ':')
+	dash := strings.LastIndexByte(fields[0], '-')
+	if colon < 0 || dash < colon {
+		return e, fmt.Errorf("xmfa header %q: expect seq:start-end", header)
+	}
+
+	var err1, err2, err3 error
+	e.SeqIndex, err1 = strconv.Atoi(fields[0][:colon])
+	e.Start, err2 = strconv.Atoi(fields[0][colon+1 : dash])
+	e.End, err3 = strconv.Atoi(fields[0][dash+1:])
+	for _, err := range []error{err1, err2, err3} {
+		if err != nil {
+			return e, fmt.Errorf("xmfa header %q: %v", header, err)
+		}
+	}
+
+	if fields[1] != "+" && fields[1] != "-" {
+		return e, fmt.Errorf("xmfa header %q: strand %q", header, fields[1])
+	}
+	e.Strand = fields[1][0]
+	e.Comment = strings.Join(fields[2:], " ")
+
+	return e, nil
+}
+
+// ParseBlock parses the headers of the sequences of a block.
+func ParseBlock(sequences []*seq.Sequence) (Block, error) {
+	var b Block
+	for _, s := range sequences {
+		e, err := ParseHeader(s.Id)
+		if err != nil {
+			return b, err
+		}
+		e.Seq = s
+		b.Entries = append(b.Entries, e)
+	}
+	return b, nil
+}
+
+// Sequences returns the sequences of the block.
+func (b Block) Sequences() []*seq.Sequence {
+	var sequences []*seq.Sequence
+	for _, e := range b.Entries {
+		sequences = append(sequences, e.Seq)
+	}
+	return sequences
+}
+
+// Entry returns the entry of a source sequence, or false if it is absent.
+func (b Block) Entry(seqIndex int) (Entry, bool) {
+	for _, e := range b.Entries {
+		if e.SeqIndex == seqIndex {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// Position returns the genomic coordinate of an alignment column,
+// or -1 if the entry has a gap there.
+func (e Entry) Position(col int) int {
+	if e.Seq.Seq[col] == '-' {
+		return -1
+	}
+
+	offset := 0
+	for i := 0; i < col; i++ {
+		if e.Seq.Seq[i] != '-' {
+			offset++
+		}
+	}
+
+	if e.Strand == '-' {
+		return e.End - offset
+	}
+	return e.Start + offset
+}
+
+// Oriented returns the block in the forward orientation of a source sequence:
+// if its entry is on the minus strand, every entry is reverse-complemented
+// and its strand flipped, so that the columns stay aligned and run
+// along increasing coordinates of that sequence.
+func (b Block) Oriented(seqIndex int) Block {
+	e, found := b.Entry(seqIndex)
+	if !found || e.Strand != '-' {
+		return b
+	}
+
+	var o Block
+	for _, e := range b.Entries {
+		s := seq.NewSequence(e.Seq.Id, ReverseComplement(e.Seq.Seq))
+		if e.Strand == '-' {
+			e.Strand = '+'
+		} else {
+			e.Strand = '-'
+		}
+		e.Seq = s
+		o.Entries = append(o.Entries, e)
+	}
+	return o
+}
+
+// SortBlocks sorts blocks by the start of the entries of a source sequence;
+// blocks without that sequence go last, in their original order.
+func SortBlocks(blocks []Block, seqIndex int) {
+	start := func(b Block) int {
+		e, found := b.Entry(seqIndex)
+		if !found || e.Start == 0 {
+			return int(^uint(0) >> 1)
+		}
+		return e.Start
+	}
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return start(blocks[i]) < start(blocks[j])
+	})
+}
+
+var complements = map[byte]byte{
+	'A': 'T', 'C': 'G', 'G': 'C', 'T': 'A',
+	'a': 't', 'c': 'g', 'g': 'c', 't': 'a',
+	'R': 'Y', 'Y': 'R', 'K': 'M', 'M': 'K', 'B': 'V', 'V': 'B', 'D': 'H', 'H': 'D',
+	'r': 'y', 'y': 'r', 'k': 'm', 'm': 'k', 'b': 'v', 'v': 'b', 'd': 'h', 'h': 'd',
+}
+
+// ReverseComplement returns the reverse complement of a nucleotide sequence;
+// gaps and other symbols, such as N, S and W, are kept.
+func ReverseComplement(s []byte) []byte {
+	rc := make([]byte, len(s))
+	for i, c := range s {
+		if d, found := complements[c]; found {
+			c = d
+		}
+		rc[len(s)-1-i] = c
+	}
+	return rc
+}
@@ -0,0 +1,57 @@
+package io
+
+import (
+	"github.com/mingzhi/biogo/seq"
+	"testing"
+)
+
+func TestParseHeader(t *testing.T) {
+	e, err := ParseHeader("> 2:101-200 - adk gene")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.SeqIndex != 2 || e.Start != 101 || e.End != 200 || e.Strand != '-' || e.Comment != "adk gene" {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+
+	for _, h := range []string{"1:1-10", "x:1-10 +", "1:1-10 *", "1-10 +"} {
+		if _, err := ParseHeader(h); err == nil {
+			t.Errorf("Expect an error for %q", h)
+		}
+	}
+}
+
+func TestOrientedAndSorted(t *testing.T) {
+	b1, _ := ParseBlock([]*seq.Sequence{
+		seq.NewSequence("1:11-14 -", []byte("AAC-G")),
+		seq.NewSequence("2:1-5 +", []byte("AACTG")),
+	})
+	b2, _ := ParseBlock([]*seq.Sequence{
+		seq.NewSequence("1:1-3 +", []byte("TTT")),
+		seq.NewSequence("2:6-8 +", []byte("TTA")),
+	})
+
+	if p := b1.Entries[0].Position(0); p != 14 {
+		t.Errorf("Expect position 14, got %d", p)
+	}
+	if p := b1.Entries[0].Position(4); p != 11 {
+		t.Errorf("Expect position 11, got %d", p)
+	}
+
+	o := b1.Oriented(1)
+	if s := string(o.Entries[0].Seq.Seq); s != "C-GTT" {
+		t.Errorf("Expect C-GTT, got %s", s)
+	}
+	if o.Entries[0].Strand != '+' || o.Entries[1].Strand != '-' {
+		t.Errorf("Expect flipped strands, got %c %c", o.Entries[0].Strand, o.Entries[1].Strand)
+	}
+	if p := o.Entries[0].Position(0); p != 11 {
+		t.Errorf("Expect position 11, got %d", p)
+	}
+
+	blocks := []Block{b1, b2}
+	SortBlocks(blocks, 1)
+	if blocks[0].Entries[0].Start != 1 {
+		t.Errorf("Expect the block at 1 first, got %+v", blocks[0].Entries[0])
+	}
+}
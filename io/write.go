@@ -0,0 +1,269 @@
+package io
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/mingzhi/biogo/seq"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Formats lists the formats accepted by WriteAlignment.
+var Formats = []string{"xmfa", "fasta", "phylip", "nexus", "vcf"}
+
+// WriteAlignment writes alignment blocks in a format of Formats.
+// Every format but XMFA concatenates the blocks.
+func WriteAlignment(w io.Writer, format string, blocks [][]*seq.Sequence) error {
+	switch format {
+	case "xmfa":
+		return WriteXMFA(w, blocks)
+	case "fasta":
+		return WriteFasta(w, blocks)
+	case "phylip":
+		return WritePhylip(w, blocks)
+	case "nexus":
+		return WriteNexus(w, blocks)
+	case "vcf":
+		return WriteVCF(w, blocks)
+	}
+	return fmt.Errorf("unknown alignment format %q", format)
+}
+
+// WriteXMFA writes alignment blocks in XMFA, which ReadXMFA reads back.
+func WriteXMFA(w io.Writer, blocks [][]*seq.Sequence) error {
+	bw := bufio.NewWriter(w)
+	for _, block := range blocks {
+		for _, s := range block {
+			fmt.Fprintf(bw, ">%s\n", s.Id)
+			writeWrapped(bw, s.Seq, 80)
+		}
+		bw.WriteString("=\n")
+	}
+	return bw.Flush()
+}
+
+// WriteFasta writes the concatenated alignment in multi-FASTA,
+// as read by ClonalFrameML and Gubbins.
+func WriteFasta(w io.Writer, blocks [][]*seq.Sequence) error {
+	names, seqs, err := Concatenate(blocks)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	for i := range names {
+		fmt.Fprintf(bw, ">%s\n", names[i])
+		writeWrapped(bw, seqs[i], 80)
+	}
+	return bw.Flush()
+}
+
+// WritePhylip writes the concatenated alignment in relaxed sequential PHYLIP.
+func WritePhylip(w io.Writer, blocks [][]*seq.Sequence) error {
+	names, seqs, err := Concatenate(blocks)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%d %d\n", len(names), alignmentLength(seqs))
+	for i := range names {
+		fmt.Fprintf(bw, "%s %s\n", names[i], seqs[i])
+	}
+	return bw.Flush()
+}
+
+// WriteNexus writes the concatenated alignment in a NEXUS data block.
+func WriteNexus(w io.Writer, blocks [][]*seq.Sequence) error {
+	names, seqs, err := Concatenate(blocks)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	bw.WriteString("#NEXUS\n\nBEGIN DATA;\n")
+	fmt.Fprintf(bw, "\tDIMENSIONS NTAX=%d NCHAR=%d;\n", len(names), alignmentLength(seqs))
+	bw.WriteString("\tFORMAT DATATYPE=DNA MISSING=N GAP=-;\n\tMATRIX\n")
+	for i := range names {
+		fmt.Fprintf(bw, "\t%s %s\n", names[i], seqs[i])
+	}
+	bw.WriteString("\t;\nEND;\n")
+	return bw.Flush()
+}
+
+// WriteVCF writes the segregating sites of the concatenated alignment
+// as haploid genotypes of a single contig named "1".
+// The most frequent base is the reference allele;
+// gaps and ambiguous bases are missing genotypes.
+func WriteVCF(w io.Writer, blocks [][]*seq.Sequence) error {
+	names, seqs, err := Concatenate(blocks)
+	if err != nil {
+		return err
+	}
+	length := alignmentLength(seqs)
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("##fileformat=VCFv4.2\n##source=simmlst\n")
+	fmt.Fprintf(bw, "##contig=<ID=1,length=%d>\n", length)
+	bw.WriteString("##FORMAT=<ID=GT,Number=1,Type=String,Description=\"Genotype\">\n")
+	bw.WriteString("#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\t")
+	bw.WriteString(strings.Join(names, "\t"))
+	bw.WriteString("\n")
+
+	for k := 0; k < length; k++ {
+		counts := make(map[byte]int)
+		for _, s := range seqs {
+			if isBase(s[k]) {
+				counts[upper(s[k])]++
+			}
+		}
+		if len(counts) < 2 {
+			continue
+		}
+
+		alleles := make([]byte, 0, len(counts))
+		for b := range counts {
+			alleles = append(alleles, b)
+		}
+		sort.Slice(alleles, func(i, j int) bool {
+			if counts[alleles[i]] != counts[alleles[j]] {
+				return counts[alleles[i]] > counts[alleles[j]]
+			}
+			return alleles[i] < alleles[j]
+		})
+
+		var alts []string
+		for _, b := range alleles[1:] {
+			alts = append(alts, string(b))
+		}
+		fmt.Fprintf(bw, "1\t%d\t.\t%c\t%s\t.\tPASS\t.\tGT", k+1, alleles[0], strings.Join(alts, ","))
+		for _, s := range seqs {
+			gt := "."
+			if isBase(s[k]) {
+				gt = fmt.Sprintf("%d", strings.IndexByte(string(alleles), upper(s[k])))
+			}
+			bw.WriteString("\t" + gt)
+		}
+		bw.WriteString("\n")
+	}
+
+	return bw.Flush()
+}
+
+// Concatenate joins alignment blocks into one sequence per isolate.
+// If every header parses, isolates are matched across blocks by their
+// sequence index, named S1, S2, ..., and filled with gaps where absent;
+// otherwise they are matched by order and named after the first block.
+// Blocks that would give isolates sequences of different lengths are
+// an error: blocks with sequences of different lengths, with a sequence
+// index twice, or, matched by order, with different numbers of sequences.
+func Concatenate(blocks [][]*seq.Sequence) (names []string, seqs [][]byte, err error) {
+	for b, block := range blocks {
+		for _, s := range block {
+			if len(s.Seq) != len(block[0].Seq) {
+				return nil, nil, fmt.Errorf("block %d: sequences of lengths %d and %d", b+1, len(block[0].Seq), len(s.Seq))
+			}
+		}
+	}
+
+	var parsed []Block
+	for _, block := range blocks {
+		b, err := ParseBlock(block)
+		if err != nil {
+			parsed = nil
+			break
+		}
+		parsed = append(parsed, b)
+	}
+
+	if parsed == nil {
+		for b, block := range blocks {
+			if b > 0 && len(block) != len(blocks[0]) {
+				return nil, nil, fmt.Errorf("block %d: %d sequences, expect %d as the first block", b+1, len(block), len(blocks[0]))
+			}
+			for i, s := range block {
+				if b == 0 {
+					name := fmt.Sprintf("S%d", i+1)
+					if fields := strings.Fields(s.Id); len(fields) > 0 {
+						name = fields[0]
+					}
+					names = append(names, name)
+					seqs = append(seqs, nil)
+				}
+				seqs[i] = append(seqs[i], s.Seq...)
+			}
+		}
+		return
+	}
+
+	var indices []int
+	seen := make(map[int]bool)
+	for _, b := range parsed {
+		for _, e := range b.Entries {
+			if !seen[e.SeqIndex] {
+				seen[e.SeqIndex] = true
+				indices = append(indices, e.SeqIndex)
+			}
+		}
+	}
+	sort.Ints(indices)
+	rows := make(map[int]int)
+	for i, index := range indices {
+		rows[index] = i
+		names = append(names, fmt.Sprintf("S%d", index))
+	}
+
+	seqs = make([][]byte, len(indices))
+	for i, b := range parsed {
+		length := 0
+		if len(b.Entries) > 0 {
+			length = len(b.Entries[0].Seq.Seq)
+		}
+		filled := make([]bool, len(indices))
+		for _, e := range b.Entries {
+			row := rows[e.SeqIndex]
+			if filled[row] {
+				return nil, nil, fmt.Errorf("block %d: sequence %d appears twice", i+1, e.SeqIndex)
+			}
+			seqs[row] = append(seqs[row], e.Seq.Seq...)
+			filled[row] = true
+		}
+		for row := range seqs {
+			if !filled[row] {
+				seqs[row] = append(seqs[row], []byte(strings.Repeat("-", length))...)
+			}
+		}
+	}
+
+	return
+}
+
+func writeWrapped(w *bufio.Writer, s []byte, width int) {
+	for len(s) > width {
+		w.Write(s[:width])
+		w.WriteByte('\n')
+		s = s[width:]
+	}
+	w.Write(s)
+	w.WriteByte('\n')
+}
+
+func alignmentLength(seqs [][]byte) int {
+	if len(seqs) == 0 {
+		return 0
+	}
+	return len(seqs[0])
+}
+
+func isBase(b byte) bool {
+	switch upper(b) {
+	case 'A', 'C', 'G', 'T':
+		return true
+	}
+	return false
+}
+
+func upper(b byte) byte {
+	if b >= 'a' && b <= 'z' {
+		return b - 'a' + 'A'
+	}
+	return b
+}
@@ -0,0 +1,98 @@
+package io
+
+import (
+	"bytes"
+	"github.com/mingzhi/biogo/seq"
+	"strings"
+	"testing"
+)
+
+func testBlocks() [][]*seq.Sequence {
+	return [][]*seq.Sequence{
+		{
+			seq.NewSequence("1:1-4 +", []byte("ACGT")),
+			seq.NewSequence("2:1-4 +", []byte("ACGA")),
+			seq.NewSequence("3:1-4 +", []byte("AN-A")),
+		},
+		{
+			seq.NewSequence("1:5-6 +", []byte("TT")),
+			seq.NewSequence("3:5-6 +", []byte("CT")),
+		},
+	}
+}
+
+func TestWriteXMFA(t *testing.T) {
+	blocks := testBlocks()
+	var b bytes.Buffer
+	if err := WriteXMFA(&b, blocks); err != nil {
+		t.Fatal(err)
+	}
+
+	xr := NewXMFAReader(&b)
+	i := 0
+	for ; xr.Next(); i++ {
+		for j, s := range xr.Block() {
+			if s.Id != blocks[i][j].Id || !bytes.Equal(s.Seq, blocks[i][j].Seq) {
+				t.Errorf("Expect %s %s, got %s %s", blocks[i][j].Id, blocks[i][j].Seq, s.Id, s.Seq)
+			}
+		}
+	}
+	if xr.Err() != nil || i != len(blocks) {
+		t.Errorf("Expect %d blocks, got %d, %v", len(blocks), i, xr.Err())
+	}
+}
+
+func TestConcatenate(t *testing.T) {
+	names, seqs, err := Concatenate(testBlocks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"S1 ACGTTT", "S2 ACGA--", "S3 AN-ACT"}
+	for i := range expected {
+		if got := names[i] + " " + string(seqs[i]); got != expected[i] {
+			t.Errorf("Expect %s, got %s", expected[i], got)
+		}
+	}
+}
+
+func TestConcatenateRagged(t *testing.T) {
+	paralogs := testBlocks()
+	paralogs[1] = append(paralogs[1], seq.NewSequence("1:7-8 +", []byte("GG")))
+	unparsed := [][]*seq.Sequence{
+		{seq.NewSequence("a", []byte("AC")), seq.NewSequence("b", []byte("AG"))},
+		{seq.NewSequence("a", []byte("TT"))},
+	}
+	lengths := [][]*seq.Sequence{
+		{seq.NewSequence("a", []byte("AC")), seq.NewSequence("b", []byte("A"))},
+	}
+	for _, blocks := range [][][]*seq.Sequence{paralogs, unparsed, lengths} {
+		if _, _, err := Concatenate(blocks); err == nil {
+			t.Errorf("Expect an error of blocks making sequences of different lengths")
+		}
+		var b bytes.Buffer
+		if err := WritePhylip(&b, blocks); err == nil {
+			t.Errorf("Expect an error writing PHYLIP, got %q", b.String())
+		}
+	}
+}
+
+func TestWriteVCF(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteVCF(&b, testBlocks()); err != nil {
+		t.Fatal(err)
+	}
+
+	var records []string
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			records = append(records, line)
+		}
+	}
+	expected := []string{
+		"1\t4\t.\tA\tT\t.\tPASS\t.\tGT\t1\t0\t0",
+		"1\t5\t.\tC\tT\t.\tPASS\t.\tGT\t1\t.\t0",
+	}
+	if strings.Join(records, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expect records\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(records, "\n"))
+	}
+}
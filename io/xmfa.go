@@ -3,49 +3,154 @@ package io
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"github.com/mingzhi/biogo/seq"
 	"io"
 	"os"
 )
 
-func ReadXMFA(filename string) [][]*seq.Sequence {
-	seqGroups := [][]*seq.Sequence{}
+// ReadXMFA reads all alignment blocks of a XMFA file.
+func ReadXMFA(filename string) ([][]*seq.Sequence, error) {
 	f, err := os.Open(filename)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer f.Close()
 
-	var b bytes.Buffer
+	seqGroups := [][]*seq.Sequence{}
+	xr := NewXMFAReader(f)
+	for xr.Next() {
+		seqGroups = append(seqGroups, xr.Block())
+	}
+	if err := xr.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	return seqGroups, nil
+}
+
+// ParseError records the position of a malformed XMFA line.
+type ParseError struct {
+	Block int // 1-based index of the alignment block.
+	Line  int // 1-based line number.
+	Msg   string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("xmfa: block %d, line %d: %s", e.Block, e.Line, e.Msg)
+}
+
+// XMFAReader reads a XMFA stream one alignment block at a time.
+// Blocks are separated by lines starting with '=';
+// the last block may omit it.
+// Blank lines, comment lines starting with '#',
+// and carriage returns before line feeds are ignored.
+//
+//	xr := NewXMFAReader(r)
+//	for xr.Next() {
+//		block := xr.Block()
+//		...
+//	}
+//	if err := xr.Err(); err != nil {
+//		...
+//	}
+type XMFAReader struct {
+	r        *bufio.Reader
+	block    []*seq.Sequence
+	err      error
+	line     int
+	blockNum int
+}
+
+// NewXMFAReader returns a XMFAReader reading from r.
+func NewXMFAReader(r io.Reader) *XMFAReader {
+	return &XMFAReader{r: bufio.NewReader(r)}
+}
+
+// Next reads the next non-empty alignment block.
+// It returns false at the end of the stream or on error.
+func (x *XMFAReader) Next() bool {
+	x.block = nil
+	if x.err != nil {
+		return false
+	}
+
+	var block []*seq.Sequence
+	var id string
+	var buf bytes.Buffer
+	started := false
+	flush := func() {
+		if started {
+			s := make([]byte, buf.Len())
+			copy(s, buf.Bytes())
+			block = append(block, seq.NewSequence(id, s))
+		}
+		buf.Reset()
+		started = false
+	}
 
-	rd := bufio.NewReader(f)
+	x.blockNum++
 	for {
-		line, err := rd.ReadBytes('\n')
-		if err != nil {
-			if err != io.EOF {
-				panic(err)
+		line, err := x.r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			x.err = err
+			return false
+		}
+		if len(line) > 0 {
+			x.line++
+		}
+		line = bytes.TrimRight(line, "\r\n")
+
+		switch {
+		case len(bytes.TrimSpace(line)) == 0 || line[0] == '#':
+		case line[0] == '=':
+			flush()
+			if len(block) > 0 {
+				return x.setBlock(block)
+			}
+			// skip empty blocks.
+			x.blockNum++
+		case line[0] == '>':
+			flush()
+			id = string(bytes.TrimSpace(line[1:]))
+			started = true
+		default:
+			if !started {
+				x.err = &ParseError{Block: x.blockNum, Line: x.line, Msg: "sequence data before a header"}
+				return false
 			}
-			break
+			buf.Write(bytes.TrimSpace(line))
 		}
 
-		if line[0] != '=' {
-			b.Write(line)
-		} else {
-			sequences := readFasta(&b)
-			seqGroups = append(seqGroups, sequences)
-			b = *new(bytes.Buffer)
+		if err == io.EOF {
+			flush()
+			if len(block) > 0 {
+				return x.setBlock(block)
+			}
+			return false
 		}
 	}
-
-	return seqGroups
 }
 
-func readFasta(b io.Reader) []*seq.Sequence {
-	fastaReader := seq.NewFastaReader(b)
-	sequences, err := fastaReader.ReadAll()
-	if err != nil {
-		panic(err)
+// setBlock checks that the sequences of a block are aligned.
+func (x *XMFAReader) setBlock(block []*seq.Sequence) bool {
+	for _, s := range block[1:] {
+		if len(s.Seq) != len(block[0].Seq) {
+			msg := fmt.Sprintf("sequence %s has length %d, expect %d", s.Id, len(s.Seq), len(block[0].Seq))
+			x.err = &ParseError{Block: x.blockNum, Line: x.line, Msg: msg}
+			return false
+		}
 	}
+	x.block = block
+	return true
+}
+
+// Block returns the block read by the last call to Next.
+func (x *XMFAReader) Block() []*seq.Sequence {
+	return x.block
+}
 
-	return sequences
+// Err returns the first error met, or nil at the end of the stream.
+func (x *XMFAReader) Err() error {
+	return x.err
 }
@@ -0,0 +1,63 @@
+package io
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestXMFAReader(t *testing.T) {
+	input := "#FormatVersion Mauve1\r\n" +
+		"> 1:1-4 +\r\nAC\r\nGT\r\n\r\n> 2:1-4 +\r\nACGA\r\n=\r\n" +
+		"\n=\n" +
+		"> 1:5-7 +\nTTT\n> 2:5-7 +\nTTA"
+
+	xr := NewXMFAReader(strings.NewReader(input))
+	var blocks [][]string
+	for xr.Next() {
+		var block []string
+		for _, s := range xr.Block() {
+			block = append(block, s.Id+":"+string(s.Seq))
+		}
+		blocks = append(blocks, block)
+	}
+	if err := xr.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{
+		{"1:1-4 +:ACGT", "2:1-4 +:ACGA"},
+		{"1:5-7 +:TTT", "2:5-7 +:TTA"},
+	}
+	if len(blocks) != len(expected) {
+		t.Fatalf("Expect %d blocks, got %d: %v", len(expected), len(blocks), blocks)
+	}
+	for i := range expected {
+		if strings.Join(blocks[i], ",") != strings.Join(expected[i], ",") {
+			t.Errorf("Expect block %v, got %v", expected[i], blocks[i])
+		}
+	}
+}
+
+func TestXMFAReaderErrors(t *testing.T) {
+	tests := []struct {
+		input       string
+		block, line int
+	}{
+		{"> a\nAC\n=\nAC\n", 2, 4},
+		{"> a\nAC\n> b\nA\n=\n", 1, 5},
+	}
+
+	for _, test := range tests {
+		xr := NewXMFAReader(strings.NewReader(test.input))
+		for xr.Next() {
+		}
+		err, ok := xr.Err().(*ParseError)
+		if !ok {
+			t.Errorf("Expect a ParseError for %q, got %v", test.input, xr.Err())
+			continue
+		}
+		if err.Block != test.block || err.Line != test.line {
+			t.Errorf("Expect block %d, line %d, got %v", test.block, test.line, err)
+		}
+	}
+}
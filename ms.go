@@ -0,0 +1,99 @@
+package simmlst
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/mingzhi/simmlst/coalescent"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// readMS converts the first sample of an ms-style output
+// into n sequences of a given length.
+// Segregating sites at relative positions are placed at the site below,
+// or the next free one after it, wrapping around, if taken,
+// on a random ancestral sequence, with a random derived base.
+func readMS(out []byte, n, length int, rng *rand.Rand) ([][]byte, error) {
+	ancestor := make([]byte, length)
+	for i := range ancestor {
+		ancestor[i] = coalescent.Bases[rng.Intn(len(coalescent.Bases))]
+	}
+	sequences := make([][]byte, n)
+	for i := range sequences {
+		sequences[i] = append([]byte(nil), ancestor...)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(nil, 1<<30)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "//") {
+			break
+		}
+	}
+
+	var segsites int
+	var sites []int
+	var derived []byte
+	row := 0
+	for row < n && scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "segsites:"):
+			v, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "segsites:")))
+			if err != nil {
+				return nil, fmt.Errorf("ms output: %v", err)
+			}
+			segsites = v
+			if segsites == 0 {
+				return sequences, nil
+			}
+		case strings.HasPrefix(line, "positions:"):
+			fields := strings.Fields(strings.TrimPrefix(line, "positions:"))
+			if len(fields) != segsites {
+				return nil, fmt.Errorf("ms output: %d positions, expect %d", len(fields), segsites)
+			}
+			if segsites > length {
+				return nil, fmt.Errorf("ms output: %d segregating sites, more than %d sites", segsites, length)
+			}
+			taken := make([]bool, length)
+			for _, f := range fields {
+				pos, err := strconv.ParseFloat(f, 64)
+				if err != nil {
+					return nil, fmt.Errorf("ms output: %v", err)
+				}
+				site := int(pos * float64(length))
+				if site >= length {
+					site = length - 1
+				}
+				for taken[site] {
+					site = (site + 1) % length
+				}
+				taken[site] = true
+				sites = append(sites, site)
+				b := strings.IndexByte(coalescent.Bases, ancestor[site])
+				derived = append(derived, coalescent.Bases[(b+1+rng.Intn(3))%4])
+			}
+		default:
+			if len(line) != segsites || len(sites) != segsites {
+				return nil, fmt.Errorf("ms output: haplotype %d has %d sites, expect %d", row, len(line), segsites)
+			}
+			for k := 0; k < segsites; k++ {
+				if line[k] == '1' {
+					sequences[row][sites[k]] = derived[k]
+				}
+			}
+			row++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if row < n {
+		return nil, fmt.Errorf("ms output: %d haplotypes, expect %d", row, n)
+	}
+
+	return sequences, nil
+}
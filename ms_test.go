@@ -0,0 +1,28 @@
+package simmlst
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestReadMSCollisions(t *testing.T) {
+	// positions 0.11 and 0.12 fall on site 1, and 0.95 and 0.99 on site 9.
+	out := []byte("ms 2 1 -t 5\n1 2 3\n\n//\nsegsites: 4\npositions: 0.11 0.12 0.95 0.99\n1111\n0000\n")
+	sequences, err := readMS(out, 2, 10, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sites []int
+	for k := range sequences[0] {
+		if sequences[0][k] != sequences[1][k] {
+			sites = append(sites, k)
+		}
+	}
+	if len(sites) != 4 || sites[0] != 0 || sites[1] != 1 || sites[2] != 2 || sites[3] != 9 {
+		t.Errorf("Expect segregating sites 0, 1, 2 and 9, got %v", sites)
+	}
+
+	if _, err := readMS([]byte("//\nsegsites: 3\npositions: 0.1 0.2 0.3\n111\n000\n"), 2, 2, rand.New(rand.NewSource(1))); err == nil {
+		t.Errorf("Expect an error of 3 segregating sites in 2 sites")
+	}
+}
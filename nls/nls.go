@@ -0,0 +1,265 @@
+// Package nls fits models by weighted nonlinear least squares,
+// with the Levenberg-Marquardt method.
+package nls
+
+import (
+	"math"
+)
+
+// Func is a model of y at x with parameters p.
+type Func func(x float64, p []float64) float64
+
+// Options controls a fit.
+type Options struct {
+	MaxIter int     // iterations; 0 for 200.
+	Tol     float64 // relative change of the chi-square at convergence; 0 for 1e-10.
+	// bounds of the parameters, if not nil; steps are projected within them.
+	Lower, Upper []float64
+}
+
+// clamp projects parameters within the bounds.
+func (o Options) clamp(p []float64) {
+	for i := range p {
+		if o.Lower != nil && p[i] < o.Lower[i] {
+			p[i] = o.Lower[i]
+		}
+		if o.Upper != nil && p[i] > o.Upper[i] {
+			p[i] = o.Upper[i]
+		}
+	}
+}
+
+// Result is a fit and its uncertainty.
+// With weights, they are the inverse variances of y, and Cov is the inverse
+// of the curvature of the chi-square; without weights, Cov is scaled
+// by the reduced chi-square, which estimates the variance of y.
+type Result struct {
+	Params     []float64
+	Cov        [][]float64 // covariance of the parameters, nil if singular.
+	SE         []float64   // standard errors of the parameters, nil if singular.
+	RSS        float64     // weighted residual sum of squares, the chi-square.
+	DOF        int         // degrees of freedom: points less parameters.
+	RedChiSq   float64     // RSS / DOF, 0 if DOF is not positive.
+	Weighted   bool
+	Iterations int
+	Converged  bool
+	Status     string // converged, max iterations, stalled, singular, or invalid start.
+}
+
+// Fit fits f to points (x, y) with weights w, which may be nil,
+// from the parameters p0, projected within the bounds.
+func Fit(f Func, x, y, w, p0 []float64, opts Options) Result {
+	if opts.MaxIter == 0 {
+		opts.MaxIter = 200
+	}
+	if opts.Tol == 0 {
+		opts.Tol = 1e-10
+	}
+	weighted := w != nil
+	if !weighted {
+		w = make([]float64, len(x))
+		for i := range w {
+			w[i] = 1
+		}
+	}
+
+	m := len(p0)
+	p := append([]float64{}, p0...)
+	opts.clamp(p)
+	res := Result{Params: p, Weighted: weighted, DOF: len(x) - m}
+	chi := chiSquare(f, x, y, w, p)
+	if math.IsNaN(chi) || math.IsInf(chi, 0) {
+		res.Status = "invalid start"
+		return res
+	}
+
+	lambda := 1e-3
+	res.Status = "max iterations"
+	for res.Iterations < opts.MaxIter {
+		res.Iterations++
+		a, g := normal(f, x, y, w, p)
+
+		// try steps of growing damping until the chi-square decreases.
+		improved := false
+		for lambda < 1e16 {
+			damped := make([][]float64, m)
+			for i := range damped {
+				damped[i] = append([]float64{}, a[i]...)
+				damped[i][i] += lambda * a[i][i]
+				if damped[i][i] == 0 {
+					damped[i][i] = lambda
+				}
+			}
+			delta, ok := solve(damped, g)
+			if ok {
+				next := make([]float64, m)
+				for i := range next {
+					next[i] = p[i] + delta[i]
+				}
+				opts.clamp(next)
+				c := chiSquare(f, x, y, w, next)
+				if c <= chi {
+					done := chi-c <= opts.Tol*chi
+					p, chi = next, c
+					lambda /= 10
+					improved = true
+					if done {
+						res.Converged = true
+					}
+					break
+				}
+			}
+			lambda *= 10
+		}
+		if !improved {
+			res.Status = "stalled"
+			break
+		}
+		if res.Converged {
+			res.Status = "converged"
+			break
+		}
+	}
+
+	res.Params = p
+	res.RSS = chi
+	if res.DOF > 0 {
+		res.RedChiSq = chi / float64(res.DOF)
+	}
+	a, _ := normal(f, x, y, w, p)
+	cov, ok := invert(a)
+	if !ok {
+		res.Status = "singular"
+		res.Converged = false
+		return res
+	}
+	for i := range cov {
+		for j := range cov[i] {
+			if !weighted {
+				cov[i][j] *= res.RedChiSq
+			}
+		}
+		res.SE = append(res.SE, math.Sqrt(math.Abs(cov[i][i])))
+	}
+	res.Cov = cov
+	return res
+}
+
+func chiSquare(f Func, x, y, w, p []float64) float64 {
+	var chi float64
+	for i := range x {
+		r := y[i] - f(x[i], p)
+		chi += w[i] * r * r
+	}
+	return chi
+}
+
+// normal returns the normal equations J'WJ and J'Wr of the residuals r,
+// with the Jacobian J by central differences.
+func normal(f Func, x, y, w, p []float64) (a [][]float64, g []float64) {
+	m := len(p)
+	jac := make([][]float64, len(x))
+	for i := range jac {
+		jac[i] = make([]float64, m)
+	}
+	q := append([]float64{}, p...)
+	for j := range p {
+		h := 1e-6 * math.Abs(p[j])
+		if h == 0 {
+			h = 1e-8
+		}
+		q[j] = p[j] + h
+		for i := range x {
+			jac[i][j] = f(x[i], q)
+		}
+		q[j] = p[j] - h
+		for i := range x {
+			jac[i][j] = (jac[i][j] - f(x[i], q)) / (2 * h)
+		}
+		q[j] = p[j]
+	}
+
+	a = make([][]float64, m)
+	g = make([]float64, m)
+	for j := range a {
+		a[j] = make([]float64, m)
+	}
+	for i := range x {
+		r := y[i] - f(x[i], p)
+		for j := 0; j < m; j++ {
+			g[j] += w[i] * jac[i][j] * r
+			for k := 0; k < m; k++ {
+				a[j][k] += w[i] * jac[i][j] * jac[i][k]
+			}
+		}
+	}
+	return
+}
+
+// solve solves a x = b by Gaussian elimination with partial pivoting.
+func solve(a [][]float64, b []float64) ([]float64, bool) {
+	n := len(b)
+	aug := make([][]float64, n)
+	for i := range aug {
+		aug[i] = append(append([]float64{}, a[i]...), b[i])
+	}
+	if !eliminate(aug) {
+		return nil, false
+	}
+	x := make([]float64, n)
+	for i := range x {
+		x[i] = aug[i][n]
+	}
+	return x, true
+}
+
+// invert returns the inverse of a.
+func invert(a [][]float64) ([][]float64, bool) {
+	n := len(a)
+	aug := make([][]float64, n)
+	for i := range aug {
+		aug[i] = make([]float64, 2*n)
+		copy(aug[i], a[i])
+		aug[i][n+i] = 1
+	}
+	if !eliminate(aug) {
+		return nil, false
+	}
+	inv := make([][]float64, n)
+	for i := range inv {
+		inv[i] = aug[i][n:]
+	}
+	return inv, true
+}
+
+// eliminate reduces the left square of an augmented matrix to the identity
+// by Gauss-Jordan elimination, and returns false if it is singular.
+func eliminate(aug [][]float64) bool {
+	n := len(aug)
+	for c := 0; c < n; c++ {
+		pivot := c
+		for r := c + 1; r < n; r++ {
+			if math.Abs(aug[r][c]) > math.Abs(aug[pivot][c]) {
+				pivot = r
+			}
+		}
+		if aug[pivot][c] == 0 || math.IsNaN(aug[pivot][c]) {
+			return false
+		}
+		aug[c], aug[pivot] = aug[pivot], aug[c]
+		d := aug[c][c]
+		for k := range aug[c] {
+			aug[c][k] /= d
+		}
+		for r := 0; r < n; r++ {
+			if r == c || aug[r][c] == 0 {
+				continue
+			}
+			e := aug[r][c]
+			for k := range aug[r] {
+				aug[r][k] -= e * aug[c][k]
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package nls
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestFitLine(t *testing.T) {
+	// weighted least squares of a line has a closed form.
+	x := []float64{0, 1, 2, 3, 4, 5}
+	y := []float64{1.1, 2.9, 5.2, 6.8, 9.1, 11.0}
+	w := []float64{1, 2, 1, 4, 1, 2}
+	line := func(x float64, p []float64) float64 { return p[0] + p[1]*x }
+	res := Fit(line, x, y, w, []float64{0, 0}, Options{})
+
+	var s, sx, sxx, sy, sxy float64
+	for i := range x {
+		s += w[i]
+		sx += w[i] * x[i]
+		sxx += w[i] * x[i] * x[i]
+		sy += w[i] * y[i]
+		sxy += w[i] * x[i] * y[i]
+	}
+	d := s*sxx - sx*sx
+	a, b := (sxx*sy-sx*sxy)/d, (s*sxy-sx*sy)/d
+	if !res.Converged || math.Abs(res.Params[0]-a) > 1e-6 || math.Abs(res.Params[1]-b) > 1e-6 {
+		t.Errorf("Expect a converged fit of %g and %g, got %+v", a, b, res)
+	}
+	if res.SE == nil || math.Abs(res.SE[0]-math.Sqrt(sxx/d)) > 1e-6 || math.Abs(res.SE[1]-math.Sqrt(s/d)) > 1e-6 {
+		t.Errorf("Expect standard errors %g and %g, got %v", math.Sqrt(sxx/d), math.Sqrt(s/d), res.SE)
+	}
+	if res.DOF != 4 || math.Abs(res.RedChiSq-res.RSS/4) > 1e-12 {
+		t.Errorf("Expect 4 degrees of freedom, got %d and reduced chi-square %g", res.DOF, res.RedChiSq)
+	}
+}
+
+func TestFitExp(t *testing.T) {
+	model := func(x float64, p []float64) float64 { return p[0] + p[1]*math.Exp(-x/p[2]) }
+	truth := []float64{0.1, 2, 15}
+	r := rand.New(rand.NewSource(1))
+	var x, y, w []float64
+	for i := 1; i < 100; i++ {
+		sigma := 0.01 * (1 + float64(i)/50)
+		x = append(x, float64(i))
+		y = append(y, model(float64(i), truth)+sigma*r.NormFloat64())
+		w = append(w, 1/(sigma*sigma))
+	}
+
+	res := Fit(model, x, y, w, []float64{0, 1, 5}, Options{})
+	if !res.Converged {
+		t.Fatalf("Expect convergence, got %+v", res)
+	}
+	for i, v := range truth {
+		if math.Abs(res.Params[i]-v) > 4*res.SE[i] {
+			t.Errorf("Expect parameter %d of %g within 4 standard errors of %g, got %g", i, v, res.SE[i], res.Params[i])
+		}
+	}
+	if res.RedChiSq < 0.6 || res.RedChiSq > 1.5 {
+		t.Errorf("Expect a reduced chi-square near 1, got %g", res.RedChiSq)
+	}
+
+	res = Fit(model, x, y, nil, []float64{0, 1, math.NaN()}, Options{})
+	if res.Status != "invalid start" || res.Converged {
+		t.Errorf("Expect an invalid start at a decay length of NaN, got %+v", res)
+	}
+}
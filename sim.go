@@ -2,63 +2,339 @@ package simmlst
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"math"
 	"os/exec"
+	"strconv"
 	"strings"
 )
 
 // Config stores a set of population parameters.
+// The gene blocks are given either by Blocks, a list of lengths,
+// or by the shorthand NumGene blocks of length LenGene.
+// Theta and Rho are rates of the whole alignment,
+// or of each site if PerSite is set.
 type Config struct {
 	Theta, Rho       float64
 	N, Delta         int
 	NumGene, LenGene int
+	Blocks           []int    // lengths of the gene blocks; overrides NumGene and LenGene.
+	Loci             []string // optional names of the gene blocks, without commas or surrounding spaces.
 	Output           string
+	Seed             int64 // random seed; zero lets simmlst choose one.
+
+	PerSite    bool   // Theta and Rho are per-site rates (simmlst -T s... -R s...).
+	ClonalTree string // file to export the clonal genealogy to (simmlst -c).
+	LocalTrees string // file to export the local trees to (simmlst -l).
+	Graph      string // file to export the DOT graph to (simmlst -d).
 }
 
-func (p Config) String() string {
-	var b bytes.Buffer
+// Rates returns the mutation and recombination rates of the whole alignment.
+func (p Config) Rates() (theta, rho float64) {
+	if !p.PerSite {
+		return p.Theta, p.Rho
+	}
+
+	length := 0
+	for _, l := range p.BlockLengths() {
+		length += l
+	}
+	return p.Theta * float64(length), p.Rho * float64(length)
+}
+
+// Exports returns whether the config exports trees or graphs.
+func (p Config) Exports() bool {
+	return p.ClonalTree != "" || p.LocalTrees != "" || p.Graph != ""
+}
+
+// WithSuffix returns a copy of the config whose export files
+// have a suffix appended, so that replicates do not overwrite them.
+func (p Config) WithSuffix(suffix string) Config {
+	if p.ClonalTree != "" {
+		p.ClonalTree += suffix
+	}
+	if p.LocalTrees != "" {
+		p.LocalTrees += suffix
+	}
+	if p.Graph != "" {
+		p.Graph += suffix
+	}
+	return p
+}
+
+// ConfigError lists all problems found in a Config.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "simmlst: invalid config: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks that the config makes sense before running it,
+// and returns a *ConfigError listing every problem found.
+func (p Config) Validate() error {
+	var problems []string
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if p.N < 2 {
+		report("N = %d, must be at least 2", p.N)
+	}
+	if p.Delta <= 0 {
+		report("Delta = %d, must be positive", p.Delta)
+	}
+	if p.Theta < 0 || math.IsNaN(p.Theta) || math.IsInf(p.Theta, 0) {
+		report("Theta = %g, must be finite and not negative", p.Theta)
+	}
+	if p.Rho < 0 || math.IsNaN(p.Rho) || math.IsInf(p.Rho, 0) {
+		report("Rho = %g, must be finite and not negative", p.Rho)
+	}
+	if p.PerSite && (p.Theta > 1 || p.Rho > 1) {
+		report("per-site Theta and Rho must not exceed 1")
+	}
+
+	if len(p.Blocks) > 0 {
+		for i, l := range p.Blocks {
+			if l <= 0 {
+				report("block %d has length %d, must be positive", i, l)
+			}
+		}
+	} else {
+		if p.NumGene <= 0 {
+			report("NumGene = %d, must be positive", p.NumGene)
+		}
+		if p.LenGene <= 0 {
+			report("LenGene = %d, must be positive", p.LenGene)
+		}
+	}
+	if len(p.Loci) > 0 && len(p.Loci) != len(p.BlockLengths()) {
+		report("%d loci for %d blocks", len(p.Loci), len(p.BlockLengths()))
+	}
+	for _, name := range p.Loci {
+		if strings.Contains(name, ",") {
+			report("locus %q has a comma, which separates loci in INI files", name)
+		}
+		if name != strings.TrimSpace(name) {
+			report("locus %q has leading or trailing spaces, which INI files drop", name)
+		}
+	}
+
+	if p.Seed < 0 {
+		report("Seed = %d, must not be negative", p.Seed)
+	}
+
+	files := make(map[string]bool)
+	for _, f := range []string{p.ClonalTree, p.LocalTrees, p.Graph} {
+		if f == "" {
+			continue
+		}
+		if files[f] {
+			report("export file %s is used twice", f)
+		}
+		files[f] = true
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// BlockLengths returns the lengths of the gene blocks.
+func (p Config) BlockLengths() []int {
+	if len(p.Blocks) > 0 {
+		return p.Blocks
+	}
+
+	var lens []int
+	for i := 0; i < p.NumGene; i++ {
+		lens = append(lens, p.LenGene)
+	}
+	return lens
+}
 
-	fmt.Fprintf(&b, "theta = %g\n", p.Theta)
-	fmt.Fprintf(&b, "rho = %g\n", p.Rho)
-	fmt.Fprintf(&b, "n = %d\n", p.N)
-	fmt.Fprintf(&b, "num_gene = %d\n", p.NumGene)
-	fmt.Fprintf(&b, "len_gene = %d\n", p.LenGene)
-	fmt.Fprintf(&b, "output = %s\n", p.Output)
+// Locus returns the name of the i-th gene block, or an empty string.
+func (p Config) Locus(i int) string {
+	if i < len(p.Loci) {
+		return p.Loci[i]
+	}
+	return ""
+}
 
+// Key returns a string identifying the config, to be used as a map key.
+func (p Config) Key() string {
+	b, err := json.Marshal(p)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
+
+// String returns the config in the INI format,
+// or the error of a config that cannot be written in it.
+func (p Config) String() string {
+	var b bytes.Buffer
+	if err := WriteINI(&b, p); err != nil {
+		return err.Error()
+	}
 	return b.String()
 }
 
 func (p Config) parse() (options []string) {
 	options = append(options, []string{"-N", parseInt(p.N)}...)
 	options = append(options, []string{"-D", parseInt(p.Delta)}...)
-	options = append(options, []string{"-T", parseFloat64(p.Theta)}...)
-	options = append(options, []string{"-R", parseFloat64(p.Rho)}...)
+	if p.PerSite {
+		options = append(options, []string{"-T", "s" + parseFloat64(p.Theta)}...)
+		options = append(options, []string{"-R", "s" + parseFloat64(p.Rho)}...)
+	} else {
+		options = append(options, []string{"-T", parseFloat64(p.Theta)}...)
+		options = append(options, []string{"-R", parseFloat64(p.Rho)}...)
+	}
 
-	var blocks []string
-	for i := 0; i < p.NumGene; i++ {
-		blocks = append(blocks, parseInt(p.LenGene))
+	options = append(options, []string{"-B", joinInts(p.BlockLengths())}...)
+
+	if p.Seed != 0 {
+		options = append(options, []string{"-s", parseInt64(p.Seed)}...)
+	}
+	if p.ClonalTree != "" {
+		options = append(options, []string{"-c", p.ClonalTree}...)
+	}
+	if p.LocalTrees != "" {
+		options = append(options, []string{"-l", p.LocalTrees}...)
+	}
+	if p.Graph != "" {
+		options = append(options, []string{"-d", p.Graph}...)
 	}
-	options = append(options, []string{"-B", strings.Join(blocks, ",")}...)
 
 	return
 }
 
+// Options returns the command-line options of simmlst for the config,
+// without the output file.
+func (p Config) Options() []string {
+	return p.parse()
+}
+
+// DeriveSeed returns the seed of a replicate of a config,
+// derived deterministically from a master seed,
+// the index of the config and the index of the replicate.
+// The result is a positive 31-bit integer, so that simmlst accepts it.
+func DeriveSeed(master int64, cfgIndex, replicate int) int64 {
+	x := uint64(master)
+	x = splitmix64(x ^ uint64(cfgIndex))
+	x = splitmix64(x ^ uint64(replicate))
+	seed := int64(x >> 33)
+	if seed == 0 {
+		seed = 1
+	}
+	return seed
+}
+
+// splitmix64 is the finalizer of the SplitMix64 generator.
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// maxTail is the number of bytes of program output kept in an ExecError.
+const maxTail = 2048
+
+// ExecError records a failed simulator run.
+type ExecError struct {
+	Args     []string // command line, including the program name.
+	ExitCode int      // exit code, or -1 if the process did not exit normally.
+	Stdout   string   // tail of the standard output.
+	Stderr   string   // tail of the standard error.
+	Err      error    // underlying error.
+}
+
+func (e *ExecError) Error() string {
+	msg := fmt.Sprintf("%s: exit code %d: %v", strings.Join(e.Args, " "), e.ExitCode, e.Err)
+	if e.Stderr != "" {
+		msg += ": " + strings.TrimSpace(e.Stderr)
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error.
+func (e *ExecError) Unwrap() error {
+	return e.Err
+}
+
 // Exec run simmlst.
-func Exec(ps Config, tempfile string) {
-	var options []string
-	options = ps.parse()
-	options = append(options, []string{"-o", tempfile}...)
-	cmd := exec.Command("simmlst", options...)
+func Exec(ps Config, tempfile string) error {
+	return ExecContext(context.Background(), ps, tempfile)
+}
+
+// ExecContext runs simmlst and writes the simulated alignment to tempfile.
+// The process is killed if ctx is done before it exits;
+// any failure is returned as an *ExecError.
+func ExecContext(ctx context.Context, ps Config, tempfile string) error {
+	return External{}.Exec(ctx, ps, tempfile)
+}
+
+// command runs a program and returns its standard output.
+// Any failure is returned as an *ExecError.
+func command(ctx context.Context, program string, options []string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, program, options...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
 	err := cmd.Run()
-	if err != nil {
-		panic(err)
+	if err == nil {
+		return stdout.Bytes(), nil
+	}
+
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	exitCode := -1
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
 	}
+
+	return nil, &ExecError{
+		Args:     append([]string{program}, options...),
+		ExitCode: exitCode,
+		Stdout:   tail(stdout.Bytes(), maxTail),
+		Stderr:   tail(stderr.Bytes(), maxTail),
+		Err:      err,
+	}
+}
+
+// tail returns the last n bytes of b.
+func tail(b []byte, n int) string {
+	if len(b) > n {
+		b = b[len(b)-n:]
+	}
+	return string(b)
 }
 
 func parseInt(d int) string {
 	return fmt.Sprintf("%d", d)
 }
 
+func joinInts(values []int) string {
+	var fields []string
+	for _, v := range values {
+		fields = append(fields, parseInt(v))
+	}
+	return strings.Join(fields, ",")
+}
+
+func parseInt64(d int64) string {
+	return fmt.Sprintf("%d", d)
+}
+
+// parseFloat64 formats f without an exponent and without losing precision,
+// since per-site rates are small.
 func parseFloat64(f float64) string {
-	return fmt.Sprintf("%f", f)
+	return strconv.FormatFloat(f, 'f', -1, 64)
 }
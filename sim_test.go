@@ -1,77 +1,224 @@
 package simmlst
 
 import (
-	"github.com/mingzhi/gomath/stat/desc/meanvar"
-	"github.com/mingzhi/meta/fit"
-	. "github.com/mingzhi/simmlst/cov"
+	"bytes"
+	"context"
+	"errors"
 	. "github.com/mingzhi/simmlst/io"
+	"github.com/mingzhi/simmlst/simtest"
+	"io/ioutil"
 	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
+func TestMain(m *testing.M) {
+	simtest.Main(nil)
+	os.Exit(m.Run())
+}
+
 func TestExec(t *testing.T) {
-	var ps Config
-	ps.N = 10
-	ps.Delta = 50
-	ps.LenGene = 10000
-	ps.NumGene = 1
-	ps.Theta = 1000
-	ps.Rho = 1000
-	ps.DataFile = "test.xmfa"
+	dir, err := ioutil.TempDir("", "simmlst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := simtest.Install(dir); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
 
-	mv := meanvar.New()
-	mv2 := meanvar.New()
-	maxl := 100
+	ps := Config{Theta: 20, Rho: 10, N: 5, Delta: 50, Blocks: []int{300, 200}, Seed: 7}
+	ps.ClonalTree = filepath.Join(dir, "clonal.nwk")
+	filename := filepath.Join(dir, "out.xmfa")
+	if err := Exec(ps, filename); err != nil {
+		t.Fatal(err)
+	}
+	geneGroups, err := ReadXMFA(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(geneGroups) != 2 || len(geneGroups[0]) != 5 || len(geneGroups[1][4].Seq) != 200 {
+		t.Fatalf("Expect 2 blocks of 5 sequences of 300 and 200 sites, got %d blocks", len(geneGroups))
+	}
+	if _, err := os.Stat(ps.ClonalTree); err != nil {
+		t.Errorf("Expect the clonal genealogy exported, got %v", err)
+	}
 
-	n := 10
-	for i := 0; i < n; i++ {
-		Exec(ps)
+	// the stand-in simulates as the native backend.
+	ps.ClonalTree = ""
+	native, err := Native{}.Simulate(context.Background(), ps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for b := range native {
+		for i := range native[b] {
+			if !bytes.Equal(native[b][i].Seq, geneGroups[b][i].Seq) {
+				t.Errorf("Expect sequence %d of block %d as the native backend", i, b)
+			}
+		}
+	}
+}
 
-		geneGroups := ReadXMFA(ps.DataFile)
+func TestExecContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "simmlst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	program, err := simtest.Install(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		// ksCalculators := []*KsCalculator{}
-		ctCalculators := []*CovCalculator{}
-		for i := 0; i < len(geneGroups); i++ {
-			// ksCalculators = append(ksCalculators, CalcKs(geneGroups[i]))
-			ctCalculators = append(ctCalculators, CalcCt(geneGroups[i], maxl))
-		}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ps := Config{Theta: 20, Rho: 10, N: 5, Delta: 50, NumGene: 1, LenGene: 100}
+	err = External{Path: program}.Exec(ctx, ps, filepath.Join(dir, "out.xmfa"))
+	var e *ExecError
+	if !errors.As(err, &e) || !errors.Is(err, context.Canceled) {
+		t.Errorf("Expect an *ExecError of a canceled context, got %v", err)
+	}
+}
 
-		// ks := NewKsCalculator()
-		ct := NewCovCalculator(maxl, false)
-		for i := 0; i < len(ctCalculators); i++ {
-			// ks.Append(ksCalculators[i])
-			ct.Append(ctCalculators[i])
+func TestDeriveSeed(t *testing.T) {
+	// seeds are recorded with results, so they must not change.
+	tests := []struct {
+		master            int64
+		config, replicate int
+		seed              int64
+	}{
+		{1, 0, 0, 790680964},
+		{1, 0, 1, 1962848292},
+		{1, 1, 0, 1401122455},
+		{42, 2, 3, 1177104983},
+	}
+	for _, test := range tests {
+		if s := DeriveSeed(test.master, test.config, test.replicate); s != test.seed {
+			t.Errorf("Expect seed %d of master %d, config %d and replicate %d, got %d",
+				test.seed, test.master, test.config, test.replicate, s)
 		}
+	}
 
-		xdata := []float64{}
-		ydata := []float64{}
-		for i := 1; i < maxl; i++ {
-			xdata = append(xdata, float64(i))
-			ydata = append(ydata, ct.GetResult(i))
+	// seeds of distinct configs and replicates of a master seed differ,
+	// and are positive 31-bit integers.
+	for _, master := range []int64{0, 1, -1} {
+		seen := make(map[int64]bool)
+		for c := 0; c < 20; c++ {
+			for r := 0; r < 20; r++ {
+				s := DeriveSeed(master, c, r)
+				if s <= 0 || s > math.MaxInt32 {
+					t.Errorf("Expect a positive 31-bit seed, got %d", s)
+				}
+				if seen[s] {
+					t.Errorf("Expect distinct seeds, got %d twice", s)
+				}
+				seen[s] = true
+			}
 		}
+	}
 
-		par := fit.FitExp(xdata, ydata)
-		par2 := fit.FitHyper(xdata[:10], ydata[:10])
-		rm := par2[1] * 0.5 / (math.Sqrt(par2[0]))
+	ps := Config{Theta: 1, Rho: 1, N: 3, Delta: 1, NumGene: 1, LenGene: 10, Seed: 7}
+	if options := strings.Join(ps.Options(), " "); !strings.HasSuffix(options, "-s 7") {
+		t.Errorf("Expect the seed in the options, got %s", options)
+	}
+	ps.Seed = 0
+	for _, o := range ps.Options() {
+		if o == "-s" {
+			t.Errorf("Expect no seed in the options of seed 0")
+		}
+	}
+}
 
-		mv.Increment(rm)
-		mv2.Increment(par[2])
+func TestBlockLengths(t *testing.T) {
+	tests := []struct {
+		ps      Config
+		lengths []int
+		blocks  string
+	}{
+		{Config{NumGene: 3, LenGene: 100}, []int{100, 100, 100}, "100,100,100"},
+		{Config{Blocks: []int{300, 150, 420}}, []int{300, 150, 420}, "300,150,420"},
+		// Blocks override NumGene and LenGene.
+		{Config{NumGene: 2, LenGene: 100, Blocks: []int{50}}, []int{50}, "50"},
+	}
+	for _, test := range tests {
+		if lengths := test.ps.BlockLengths(); !reflect.DeepEqual(lengths, test.lengths) {
+			t.Errorf("Expect blocks %v of %+v, got %v", test.lengths, test.ps, lengths)
+		}
+		if options := strings.Join(test.ps.Options(), " "); !strings.Contains(options, "-B "+test.blocks) {
+			t.Errorf("Expect -B %s of %+v, got %s", test.blocks, test.ps, options)
+		}
 	}
 
-	// theta := ps.Theta / float64(2*ps.LenGene*ps.NumGene)
-	expectedRm := ps.Rho / ps.Theta
-	resultedRm := mv.Mean.GetResult()
-	stdError := math.Sqrt(mv.Var.GetResult() / float64(mv.Var.GetN()))
-	if math.Abs(expectedRm-resultedRm) > 1.96*stdError {
-		t.Errorf("Expect rm %f, got %f, at std error %f\n", expectedRm, resultedRm, stdError)
+	ps := Config{Blocks: []int{300, 150}, Loci: []string{"adk", "fumC"}}
+	if ps.Locus(1) != "fumC" || ps.Locus(2) != "" {
+		t.Errorf("Expect loci fumC and none, got %q and %q", ps.Locus(1), ps.Locus(2))
 	}
+}
 
-	// theta := ps.Theta / float64(2*ps.LenGene*ps.NumGene)
-	expectedDelta := float64(ps.Delta)
-	resultedDelta := mv2.Mean.GetResult()
-	stdErrorDelta := math.Sqrt(mv2.Var.GetResult() / float64(mv2.Var.GetN()))
-	if math.Abs(expectedDelta-resultedDelta) > 1.96*stdErrorDelta {
-		t.Errorf("Expect delta %f, got %f, at std error %f\n", expectedDelta, resultedDelta, stdErrorDelta)
+func TestOptions(t *testing.T) {
+	tests := []struct {
+		ps      Config
+		options string
+	}{
+		{Config{Theta: 20, Rho: 10, N: 5, Delta: 50, NumGene: 2, LenGene: 300},
+			"-N 5 -D 50 -T 20 -R 10 -B 300,300"},
+		// per-site rates are prefixed by s, and never written with an exponent.
+		{Config{Theta: 0.0000125, Rho: 0.01, N: 5, Delta: 50, Blocks: []int{300, 200}, PerSite: true},
+			"-N 5 -D 50 -T s0.0000125 -R s0.01 -B 300,200"},
+		{Config{Theta: 20, Rho: 10, N: 5, Delta: 50, NumGene: 1, LenGene: 300, Seed: 12345,
+			ClonalTree: "c.nwk", LocalTrees: "l.nwk", Graph: "g.dot"},
+			"-N 5 -D 50 -T 20 -R 10 -B 300 -s 12345 -c c.nwk -l l.nwk -d g.dot"},
 	}
+	for _, test := range tests {
+		if options := strings.Join(test.ps.Options(), " "); options != test.options {
+			t.Errorf("Expect options %s of %+v, got %s", test.options, test.ps, options)
+		}
+	}
+}
 
+func TestValidate(t *testing.T) {
+	valid := Config{Theta: 20, Rho: 10, N: 5, Delta: 50, NumGene: 2, LenGene: 300}
+	tests := []struct {
+		ps       Config
+		problems []string
+	}{
+		{valid, nil},
+		{Config{Theta: 20, Rho: 10, N: 5, Delta: 50, Blocks: []int{300, 200}, Loci: []string{"adk", "fumC"}}, nil},
+		// all problems are reported at once.
+		{Config{Theta: -1, Rho: math.NaN(), N: 1, Delta: 0},
+			[]string{"N = 1", "Delta = 0", "Theta = -1", "Rho = NaN", "NumGene = 0", "LenGene = 0"}},
+		{Config{Theta: 0.5, Rho: 2, N: 5, Delta: 50, Blocks: []int{300, 0}, Loci: []string{"adk"}, PerSite: true, Seed: -1},
+			[]string{"per-site", "block 1 has length 0", "1 loci for 2 blocks", "Seed = -1"}},
+		{Config{Theta: 20, Rho: 10, N: 5, Delta: 50, NumGene: 1, LenGene: 300, ClonalTree: "t.nwk", LocalTrees: "t.nwk"},
+			[]string{"t.nwk is used twice"}},
+	}
+	for _, test := range tests {
+		err := test.ps.Validate()
+		if test.problems == nil {
+			if err != nil {
+				t.Errorf("Expect %+v valid, got %v", test.ps, err)
+			}
+			continue
+		}
+		var e *ConfigError
+		if !errors.As(err, &e) {
+			t.Errorf("Expect a *ConfigError of %+v, got %v", test.ps, err)
+			continue
+		}
+		if len(e.Problems) != len(test.problems) {
+			t.Errorf("Expect %d problems of %+v, got %q", len(test.problems), test.ps, e.Problems)
+			continue
+		}
+		for i, p := range test.problems {
+			if !strings.Contains(e.Problems[i], p) {
+				t.Errorf("Expect problem %q, got %q", p, e.Problems[i])
+			}
+		}
+	}
 }
@@ -0,0 +1,179 @@
+// Package simtest runs test binaries as a stand-in for simmlst,
+// so that tests of Exec and of the commands need no external program.
+//
+// A test binary whose TestMain calls Main runs as simmlst when invoked
+// through the link made by Install, and as the command under test when
+// started by Command:
+//
+//	func TestMain(m *testing.M) {
+//		simtest.Main(main)
+//		os.Exit(m.Run())
+//	}
+//
+// The stand-in parses the options of simmlst that Config passes,
+// -N -D -T -R -B -s -c -l -d and -o, and writes the alignment simulated
+// by the coalescent package from the seed, 1 by default, in XMFA,
+// so that its output is the same as that of the native backend.
+package simtest
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/mingzhi/biogo/seq"
+	"github.com/mingzhi/simmlst/coalescent"
+	. "github.com/mingzhi/simmlst/io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Name is the name of the program stood in for.
+const Name = "simmlst"
+
+// commandEnv is set to 1 in the environment of commands started by Command.
+const commandEnv = "SIMTEST_COMMAND"
+
+// Main runs the test binary as simmlst, if invoked through Install,
+// or else as command, if started by Command, and exits; otherwise it returns.
+func Main(command func()) {
+	if filepath.Base(os.Args[0]) == Name {
+		if err := Run(os.Args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", Name, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if command != nil && os.Getenv(commandEnv) == "1" {
+		command()
+		os.Exit(0)
+	}
+}
+
+// Install links the test binary as simmlst in a directory,
+// and returns the path of the link.
+func Install(dir string) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, Name)
+	return path, os.Symlink(exe, path)
+}
+
+// Command returns the command running the test binary as the command
+// under test, with arguments.
+func Command(args ...string) (*exec.Cmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Env = append(os.Environ(), commandEnv+"=1")
+	return cmd, nil
+}
+
+// Run simulates an alignment as simmlst with its options.
+func Run(args []string) error {
+	fs := flag.NewFlagSet(Name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	n := fs.Int("N", 0, "number of isolates")
+	delta := fs.Int("D", 0, "mean length of imports")
+	theta := fs.String("T", "", "mutation rate, per site if prefixed by s")
+	rho := fs.String("R", "", "recombination rate, per site if prefixed by s")
+	blocks := fs.String("B", "", "comma-separated lengths of the blocks")
+	seed := fs.Int64("s", 1, "seed")
+	output := fs.String("o", "", "output file")
+	clonal := fs.String("c", "", "file of the clonal genealogy")
+	local := fs.String("l", "", "file of the local trees")
+	graph := fs.String("d", "", "file of the DOT graph")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	if *output == "" {
+		return errors.New("no output file (-o)")
+	}
+
+	p := coalescent.Params{N: *n, Delta: *delta}
+	length := 0
+	for _, field := range strings.Split(*blocks, ",") {
+		l, err := strconv.Atoi(field)
+		if err != nil {
+			return fmt.Errorf("-B %s: %v", *blocks, err)
+		}
+		p.Blocks = append(p.Blocks, l)
+		length += l
+	}
+	var err error
+	if p.Theta, err = rate(*theta, length); err != nil {
+		return fmt.Errorf("-T %s: %v", *theta, err)
+	}
+	if p.Rho, err = rate(*rho, length); err != nil {
+		return fmt.Errorf("-R %s: %v", *rho, err)
+	}
+
+	sim, err := coalescent.Simulate(context.Background(), p, rand.New(rand.NewSource(*seed)))
+	if err != nil {
+		return err
+	}
+
+	// exports are placeholders: a star tree of the isolates.
+	star := make([]string, p.N)
+	for i := range star {
+		star[i] = strconv.Itoa(i + 1)
+	}
+	tree := "(" + strings.Join(star, ",") + ");\n"
+	for _, export := range []struct{ file, content string }{
+		{*clonal, tree}, {*local, tree}, {*graph, "digraph {}\n"},
+	} {
+		if export.file != "" {
+			if err := ioutil.WriteFile(export.file, []byte(export.content), 0644); err != nil {
+				return err
+			}
+		}
+	}
+
+	// name sequences after the XMFA headers of simmlst.
+	var geneGroups [][]*seq.Sequence
+	start := 0
+	for b, l := range p.Blocks {
+		var sequences []*seq.Sequence
+		for i, s := range sim[b] {
+			id := fmt.Sprintf("%d:%d-%d +", i+1, start+1, start+l)
+			sequences = append(sequences, seq.NewSequence(id, s))
+		}
+		geneGroups = append(geneGroups, sequences)
+		start += l
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := WriteXMFA(f, geneGroups); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rate parses a rate of the whole alignment, or per site if prefixed by s.
+func rate(s string, length int) (float64, error) {
+	perSite := strings.HasPrefix(s, "s")
+	v, err := strconv.ParseFloat(strings.TrimPrefix(s, "s"), 64)
+	if err != nil {
+		return 0, err
+	}
+	if perSite {
+		v *= float64(length)
+	}
+	return v, nil
+}
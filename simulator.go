@@ -0,0 +1,269 @@
+package simmlst
+
+import (
+	"context"
+	"fmt"
+	"github.com/mingzhi/biogo/seq"
+	"github.com/mingzhi/simmlst/coalescent"
+	. "github.com/mingzhi/simmlst/io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+)
+
+// Simulator simulates alignments, one group of sequences per gene block.
+type Simulator interface {
+	Simulate(ctx context.Context, ps Config) ([][]*seq.Sequence, error)
+}
+
+// Backends lists the names accepted by NewSimulator.
+var Backends = []string{"external", "native", "ms", "fastsimbac"}
+
+// NewSimulator returns the simulator backend of a name:
+// "external" runs simmlst, "native" the coalescent package,
+// "ms" Hudson's ms, and "fastsimbac" FastSimBac.
+// The path of the program of external backends defaults to its name.
+func NewSimulator(name, path string) (Simulator, error) {
+	switch name {
+	case "external":
+		return External{Path: path}, nil
+	case "native":
+		return Native{}, nil
+	case "ms":
+		return MS{Path: path}, nil
+	case "fastsimbac":
+		return FastSimBac{Path: path}, nil
+	}
+	return nil, fmt.Errorf("unknown simulator backend %q", name)
+}
+
+// execer is implemented by simulators that write XMFA files themselves.
+type execer interface {
+	Exec(ctx context.Context, ps Config, filename string) error
+}
+
+// ExecWith runs a simulator and writes the alignment to filename in XMFA.
+func ExecWith(ctx context.Context, s Simulator, ps Config, filename string) error {
+	if e, ok := s.(execer); ok {
+		return e.Exec(ctx, ps, filename)
+	}
+
+	geneGroups, err := s.Simulate(ctx, ps)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return WriteXMFA(f, geneGroups)
+}
+
+// External runs the simmlst program.
+type External struct {
+	Path string // path of simmlst; empty for "simmlst" on PATH.
+}
+
+func (e External) program() string {
+	if e.Path == "" {
+		return "simmlst"
+	}
+	return e.Path
+}
+
+// Exec runs simmlst and writes the alignment to filename.
+func (e External) Exec(ctx context.Context, ps Config, filename string) error {
+	if err := ps.Validate(); err != nil {
+		return err
+	}
+
+	var options []string
+	options = ps.parse()
+	options = append(options, []string{"-o", filename}...)
+	_, err := command(ctx, e.program(), options)
+	return err
+}
+
+// Simulate runs simmlst and reads its output.
+func (e External) Simulate(ctx context.Context, ps Config) ([][]*seq.Sequence, error) {
+	tempfile, err := ioutil.TempFile("", "simmlst")
+	if err != nil {
+		return nil, err
+	}
+	tempfile.Close()
+	defer os.Remove(tempfile.Name())
+
+	if err := e.Exec(ctx, ps, tempfile.Name()); err != nil {
+		return nil, err
+	}
+
+	return ReadXMFA(tempfile.Name())
+}
+
+// Native simulates the model of simmlst with the coalescent package.
+// It does not export trees or graphs.
+type Native struct{}
+
+// Simulate runs the simulation in process.
+func (Native) Simulate(ctx context.Context, ps Config) ([][]*seq.Sequence, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := ps.Validate(); err != nil {
+		return nil, err
+	}
+	if ps.Exports() {
+		return nil, errExports("native")
+	}
+
+	var p coalescent.Params
+	p.N = ps.N
+	p.Theta, p.Rho = ps.Rates()
+	p.Delta = ps.Delta
+	p.Blocks = ps.BlockLengths()
+
+	blocks, err := coalescent.Simulate(ctx, p, newRand(ps))
+	if err != nil {
+		return nil, err
+	}
+
+	var genomes [][]byte
+	for i := 0; i < ps.N; i++ {
+		var g []byte
+		for _, block := range blocks {
+			g = append(g, block[i]...)
+		}
+		genomes = append(genomes, g)
+	}
+
+	return splitBlocks(genomes, ps), nil
+}
+
+// MS runs Hudson's ms with gene conversion and no crossing over.
+// With a zero crossing-over rate, ms takes the gene conversion rate
+// of the whole locus from -c, so that Theta and Rho keep the meaning
+// they have in simmlst. The blocks are simulated as one linked locus,
+// and tracts may span blocks.
+type MS struct {
+	Path string // path of ms; empty for "ms" on PATH.
+}
+
+// Simulate runs ms and converts its output into sequences.
+func (m MS) Simulate(ctx context.Context, ps Config) ([][]*seq.Sequence, error) {
+	program := m.Path
+	if program == "" {
+		program = "ms"
+	}
+	if err := ps.Validate(); err != nil {
+		return nil, err
+	}
+	if ps.Exports() {
+		return nil, errExports("ms")
+	}
+
+	length := sum(ps.BlockLengths())
+	theta, rho := ps.Rates()
+	rng := newRand(ps)
+	options := []string{
+		parseInt(ps.N), "1",
+		"-t", parseFloat64(theta),
+		"-r", "0", parseInt(length),
+		"-c", parseFloat64(rho), parseInt(ps.Delta),
+		"-seeds", parseInt(rng.Intn(1<<16) + 1), parseInt(rng.Intn(1<<16) + 1), parseInt(rng.Intn(1<<16) + 1),
+	}
+
+	return runMS(ctx, program, options, ps, rng)
+}
+
+// FastSimBac runs FastSimBac, which takes per-site rates.
+// The blocks are simulated as one linked genome, and tracts may span blocks.
+type FastSimBac struct {
+	Path string // path of FastSimBac; empty for "fastSimBac" on PATH.
+}
+
+// Simulate runs FastSimBac and converts its output into sequences.
+func (f FastSimBac) Simulate(ctx context.Context, ps Config) ([][]*seq.Sequence, error) {
+	program := f.Path
+	if program == "" {
+		program = "fastSimBac"
+	}
+	if err := ps.Validate(); err != nil {
+		return nil, err
+	}
+	if ps.Exports() {
+		return nil, errExports("fastsimbac")
+	}
+
+	length := sum(ps.BlockLengths())
+	theta, rho := ps.Rates()
+	rng := newRand(ps)
+	options := []string{
+		parseInt(ps.N), parseInt(length),
+		"-t", parseFloat64(theta / float64(length)),
+		"-r", parseFloat64(rho / float64(length)), parseInt(ps.Delta),
+		"-s", parseInt(rng.Intn(1<<30) + 1),
+	}
+
+	return runMS(ctx, program, options, ps, rng)
+}
+
+// runMS runs a program with ms-style output.
+func runMS(ctx context.Context, program string, options []string, ps Config, rng *rand.Rand) ([][]*seq.Sequence, error) {
+	out, err := command(ctx, program, options)
+	if err != nil {
+		return nil, err
+	}
+
+	genomes, err := readMS(out, ps.N, sum(ps.BlockLengths()), rng)
+	if err != nil {
+		return nil, err
+	}
+
+	return splitBlocks(genomes, ps), nil
+}
+
+// splitBlocks splits whole genomes into the gene blocks of a config,
+// naming sequences after XMFA headers, with locus names as comments.
+func splitBlocks(genomes [][]byte, ps Config) [][]*seq.Sequence {
+	var geneGroups [][]*seq.Sequence
+	start := 0
+	for b, l := range ps.BlockLengths() {
+		var sequences []*seq.Sequence
+		for i, g := range genomes {
+			id := fmt.Sprintf("%d:%d-%d + %s", i+1, start+1, start+l, ps.Locus(b))
+			id = strings.TrimSpace(id)
+			sequences = append(sequences, seq.NewSequence(id, g[start:start+l]))
+		}
+		geneGroups = append(geneGroups, sequences)
+		start += l
+	}
+	return geneGroups
+}
+
+// errExports reports that a backend cannot export trees or graphs.
+func errExports(backend string) error {
+	return fmt.Errorf("backend %s does not export trees or graphs", backend)
+}
+
+// newRand returns a random generator seeded by the config,
+// or by the time if the config has no seed.
+func newRand(ps Config) *rand.Rand {
+	seed := ps.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed))
+}
+
+func sum(values []int) int {
+	total := 0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
@@ -0,0 +1,452 @@
+// Package sites calculates correlation statistics of an alignment
+// from a sparse table of its segregating sites.
+//
+// Columns where all sequences have the same valid base add nothing
+// to the numerators of any statistic, so an alignment is indexed once
+// by the few columns that are segregating or have an invalid base,
+// and lag correlations are swept over pairs of those columns
+// no further apart than the maximum lag.
+package sites
+
+import (
+	"fmt"
+	"github.com/mingzhi/simmlst/cov"
+)
+
+// Table is the sparse table of the columns of an alignment
+// that are segregating or have an invalid base.
+type Table struct {
+	Length int      // length of the alignment.
+	N      int      // number of sequences.
+	Pos    []int    // sorted positions of the columns.
+	Codes  [][]byte // Codes[s][i] is the base of sequence i at Pos[s], or 0 if not valid.
+}
+
+// NewTable indexes an alignment, with gaps treated by a policy.
+func NewTable(sequences [][]byte, policy cov.GapPolicy) *Table {
+	t := &Table{N: len(sequences)}
+	if len(sequences) == 0 {
+		return t
+	}
+	t.Length = len(sequences[0])
+
+	columns := policy.Columns(sequences)
+	for k := 0; k < t.Length; k++ {
+		codes := make([]byte, t.N)
+		keep := false
+		for i, s := range sequences {
+			if columns[k] && policy.Valid(s[k]) {
+				codes[i] = upper(s[k])
+			}
+			if codes[i] == 0 || codes[i] != codes[0] {
+				keep = true
+			}
+		}
+		if keep {
+			t.Pos = append(t.Pos, k)
+			t.Codes = append(t.Codes, codes)
+		}
+	}
+
+	return t
+}
+
+// Stats accumulates the correlation statistics of alignments.
+// Statistics by pairs of sequences average over the pairs;
+// statistics by columns average over the pairs of columns at a lag,
+// within each of which the pairs of sequences are compared.
+type Stats struct {
+	MaxL     int
+	Circular bool
+
+	// by pairs of sequences.
+	Pairs int       // pairs with valid sites.
+	D, D2 float64   // sums of the substitution rates of pairs, and of their squares.
+	Cm    []float64 // sums of the lag covariances of pairs.
+	CmN   []int     // pairs with valid pairs of sites at every lag.
+
+	// pooled over pairs of sequences.
+	Subs, Sites   int64   // substitutions and valid sites.
+	XY, SitePairs []int64 // pairs of substitutions and of valid sites at every lag.
+
+	// by columns.
+	Columns        []int64   // pairs of columns with valid pairs of sequences.
+	Cs             []float64 // sums of the covariances of pairs of columns.
+	X, Y, XbarYbar []float64 // sums of the substitution rates of columns, and of their products.
+	XYCount, NXY   []int64   // pairs of substitutions and of valid pairs of sequences.
+}
+
+// NewStats returns empty statistics at lags below maxl,
+// which wrap around the ends of alignments if circular.
+func NewStats(maxl int, circular bool) *Stats {
+	s := &Stats{MaxL: maxl, Circular: circular}
+	s.Cm = make([]float64, maxl)
+	s.CmN = make([]int, maxl)
+	s.XY = make([]int64, maxl)
+	s.SitePairs = make([]int64, maxl)
+	s.Columns = make([]int64, maxl)
+	s.Cs = make([]float64, maxl)
+	s.X = make([]float64, maxl)
+	s.Y = make([]float64, maxl)
+	s.XbarYbar = make([]float64, maxl)
+	s.XYCount = make([]int64, maxl)
+	s.NXY = make([]int64, maxl)
+	return s
+}
+
+// Validate checks that the statistics have MaxL lags,
+// as statistics read from a state must have.
+func (s *Stats) Validate() error {
+	if s.MaxL < 0 {
+		return fmt.Errorf("sites: maxl %d", s.MaxL)
+	}
+	for _, n := range []int{len(s.Cm), len(s.CmN), len(s.XY), len(s.SitePairs), len(s.Columns), len(s.Cs),
+		len(s.X), len(s.Y), len(s.XbarYbar), len(s.XYCount), len(s.NXY)} {
+		if n != s.MaxL {
+			return fmt.Errorf("sites: statistics of %d lags, expect maxl %d", n, s.MaxL)
+		}
+	}
+	return nil
+}
+
+// Calc calculates the statistics of an indexed alignment.
+func Calc(t *Table, maxl int, circular bool) *Stats {
+	s := NewStats(maxl, circular)
+	s.Add(t)
+	return s
+}
+
+// Append adds the statistics of other alignments.
+func (s *Stats) Append(s2 *Stats) {
+	s.merge(s2, 1)
+}
+
+// Remove subtracts the statistics of alignments appended before.
+// Counts are exact; sums of rates and covariances are up to rounding.
+func (s *Stats) Remove(s2 *Stats) {
+	s.merge(s2, -1)
+}
+
+// AppendN adds the statistics of other alignments k times.
+func (s *Stats) AppendN(s2 *Stats, k int) {
+	s.merge(s2, int64(k))
+}
+
+func (s *Stats) merge(s2 *Stats, k int64) {
+	w := float64(k)
+	s.Pairs += int(k) * s2.Pairs
+	s.D += w * s2.D
+	s.D2 += w * s2.D2
+	s.Subs += k * s2.Subs
+	s.Sites += k * s2.Sites
+	for l := 0; l < s.MaxL; l++ {
+		s.Cm[l] += w * s2.Cm[l]
+		s.CmN[l] += int(k) * s2.CmN[l]
+		s.XY[l] += k * s2.XY[l]
+		s.SitePairs[l] += k * s2.SitePairs[l]
+		s.Columns[l] += k * s2.Columns[l]
+		s.Cs[l] += w * s2.Cs[l]
+		s.X[l] += w * s2.X[l]
+		s.Y[l] += w * s2.Y[l]
+		s.XbarYbar[l] += w * s2.XbarYbar[l]
+		s.XYCount[l] += k * s2.XYCount[l]
+		s.NXY[l] += k * s2.NXY[l]
+	}
+}
+
+// column is the contribution of a pair of columns to the statistics by columns.
+type column struct {
+	n, nx, ny, nxy int
+}
+
+func (s *Stats) addColumn(l int, c column, sign float64) {
+	if c.n == 0 {
+		return
+	}
+	n := float64(c.n)
+	xbar, ybar := float64(c.nx)/n, float64(c.ny)/n
+	s.Columns[l] += int64(sign)
+	s.Cs[l] += sign * (float64(c.nxy)/n - xbar*ybar)
+	s.X[l] += sign * xbar
+	s.Y[l] += sign * ybar
+	s.XbarYbar[l] += sign * xbar * ybar
+	s.XYCount[l] += int64(sign) * int64(c.nxy)
+	s.NXY[l] += int64(sign) * int64(c.n)
+}
+
+// Pair is the breakdown of the statistics of a pair of sequences.
+type Pair struct {
+	I, J  int       // indices of the sequences.
+	Sites int       // valid sites.
+	Ks    float64   // substitution rate.
+	Cm    []float64 // lag covariances, up to the first lag without valid pairs of sites.
+}
+
+// Add adds the statistics of an indexed alignment.
+func (s *Stats) Add(t *Table) {
+	s.add(t, nil)
+}
+
+// AddPairs adds the statistics of an indexed alignment,
+// and returns those of each of its pairs of sequences with valid sites.
+func (s *Stats) AddPairs(t *Table) []Pair {
+	pairs := []Pair{}
+	s.add(t, &pairs)
+	return pairs
+}
+
+func (s *Stats) add(t *Table, breakdown *[]Pair) {
+	L := t.Length
+	maxl := s.MaxL
+	if maxl > L {
+		maxl = L
+	}
+	numPairs := t.N * (t.N - 1) / 2
+	if numPairs == 0 || maxl == 0 {
+		return
+	}
+
+	// lagPairs returns the number of pairs of columns at lag l.
+	lagPairs := func(l int) int {
+		if s.Circular {
+			return L
+		}
+		return L - l
+	}
+
+	// statistics by columns: every pair of columns starts as a pair
+	// of invariant columns, then pairs with an indexed column are
+	// corrected, first one column at a time, then both at a time.
+	for l := 0; l < maxl; l++ {
+		s.addColumn(l, column{n: numPairs}, float64(lagPairs(l)))
+	}
+	single := make([]column, len(t.Pos))
+	for a, codes := range t.Codes {
+		var c column
+		for i := 0; i < t.N; i++ {
+			for j := i + 1; j < t.N; j++ {
+				if codes[i] != 0 && codes[j] != 0 {
+					c.n++
+					if codes[i] != codes[j] {
+						c.nx++
+					}
+				}
+			}
+		}
+		single[a] = c
+		cy := column{n: c.n, ny: c.nx}
+		for l := 0; l < maxl; l++ {
+			if s.Circular || t.Pos[a]+l < L {
+				s.addColumn(l, column{n: numPairs}, -1)
+				s.addColumn(l, c, 1)
+			}
+			if s.Circular || t.Pos[a]-l >= 0 {
+				s.addColumn(l, column{n: numPairs}, -1)
+				s.addColumn(l, cy, 1)
+			}
+		}
+	}
+
+	// statistics by pairs of sequences: xy[p][l] counts pairs of substitutions
+	// of the pair p at lag l, and both[p][l] pairs of invalid sites;
+	// the substitutions and invalid sites of p are the counts at lag 0.
+	xy := make([][]int, numPairs)
+	for p := range xy {
+		xy[p] = make([]int, maxl)
+	}
+	both := make([][]int, numPairs)
+
+	m := len(t.Pos)
+	for a := 0; a < m; a++ {
+		ca := t.Codes[a]
+		for step := 0; step < m; step++ {
+			b := a + step
+			if b >= m {
+				if !s.Circular {
+					break
+				}
+				b -= m
+			}
+			l := (t.Pos[b] - t.Pos[a] + L) % L
+			if l >= maxl {
+				break
+			}
+
+			cb := t.Codes[b]
+			var c column
+			p := 0
+			for i := 0; i < t.N; i++ {
+				for j := i + 1; j < t.N; j++ {
+					va := ca[i] != 0 && ca[j] != 0
+					vb := cb[i] != 0 && cb[j] != 0
+					switch {
+					case va && vb:
+						c.n++
+						x, y := ca[i] != ca[j], cb[i] != cb[j]
+						if x {
+							c.nx++
+						}
+						if y {
+							c.ny++
+						}
+						if x && y {
+							c.nxy++
+							xy[p][l]++
+						}
+					case !va && !vb:
+						if both[p] == nil {
+							both[p] = make([]int, maxl)
+						}
+						both[p][l]++
+					}
+					p++
+				}
+			}
+
+			s.addColumn(l, c, 1)
+			s.addColumn(l, single[a], -1)
+			s.addColumn(l, column{n: single[b].n, ny: single[b].nx}, -1)
+			s.addColumn(l, column{n: numPairs}, 1)
+		}
+	}
+
+	// invalid sites near the ends have fewer partners, if not circular:
+	// ends[p][l] counts invalid sites of p with no partner at lag l,
+	// on either side.
+	ends := make([][]int, numPairs)
+	if !s.Circular {
+		for a, codes := range t.Codes {
+			k := t.Pos[a]
+			if k >= maxl && k < L-maxl {
+				continue
+			}
+			p := 0
+			for i := 0; i < t.N; i++ {
+				for j := i + 1; j < t.N; j++ {
+					if codes[i] == 0 || codes[j] == 0 {
+						if ends[p] == nil {
+							ends[p] = make([]int, maxl+1)
+						}
+						// k+l >= L, and k-l < 0.
+						if L-k < maxl {
+							ends[p][L-k]++
+						}
+						if k+1 < maxl {
+							ends[p][k+1]++
+						}
+					}
+					p++
+				}
+			}
+		}
+		for p := range ends {
+			for l := 1; ends[p] != nil && l < maxl; l++ {
+				ends[p][l] += ends[p][l-1]
+			}
+		}
+	}
+
+	var is, js []int
+	for i := 0; i < t.N; i++ {
+		for j := i + 1; j < t.N; j++ {
+			is, js = append(is, i), append(js, j)
+		}
+	}
+
+	for p := 0; p < numPairs; p++ {
+		invalid := 0
+		if both[p] != nil {
+			invalid = both[p][0]
+		}
+		valid := L - invalid
+		if valid == 0 {
+			continue
+		}
+
+		d := float64(xy[p][0]) / float64(valid)
+		s.Pairs++
+		s.D += d
+		s.D2 += d * d
+		s.Subs += int64(xy[p][0])
+		s.Sites += int64(valid)
+
+		pair := Pair{I: is[p], J: js[p], Sites: valid, Ks: d}
+		for l := 0; l < maxl; l++ {
+			pairs := lagPairs(l) - 2*invalid
+			if both[p] != nil {
+				pairs += both[p][l]
+			}
+			if ends[p] != nil {
+				pairs += ends[p][l]
+			}
+			if pairs > 0 {
+				cm := float64(xy[p][l])/float64(pairs) - d*d
+				s.Cm[l] += cm
+				s.CmN[l]++
+				s.XY[l] += int64(xy[p][l])
+				s.SitePairs[l] += int64(pairs)
+				if len(pair.Cm) == l {
+					pair.Cm = append(pair.Cm, cm)
+				}
+			}
+		}
+		if breakdown != nil {
+			*breakdown = append(*breakdown, pair)
+		}
+	}
+}
+
+// GetKs returns the mean substitution rate of pairs of sequences.
+func (s *Stats) GetKs() float64 {
+	return s.D / float64(s.Pairs)
+}
+
+// GetVd returns the variance of the substitution rates of pairs of sequences.
+func (s *Stats) GetVd() float64 {
+	ks := s.GetKs()
+	return s.D2/float64(s.Pairs) - ks*ks
+}
+
+// GetCm returns the mean lag covariance of pairs of sequences at lag l,
+// over the pairs with valid pairs of sites at the lag.
+func (s *Stats) GetCm(l int) float64 {
+	return s.Cm[l] / float64(s.CmN[l])
+}
+
+// GetPooledKs returns the substitution rate of all valid sites,
+// as cov.CalcKs.
+func (s *Stats) GetPooledKs() float64 {
+	return float64(s.Subs) / float64(s.Sites)
+}
+
+// GetCt returns the lag covariance pooled over pairs of sequences at lag l,
+// as cov.CovCalculatorFFT.
+func (s *Stats) GetCt(l int) float64 {
+	ks := s.GetPooledKs()
+	return float64(s.XY[l])/float64(s.SitePairs[l]) - ks*ks
+}
+
+// GetCs returns the mean covariance of pairs of columns at lag l.
+func (s *Stats) GetCs(l int) float64 {
+	return s.Cs[l] / float64(s.Columns[l])
+}
+
+// GetCr returns the covariance of the substitution rates
+// of pairs of columns at lag l.
+func (s *Stats) GetCr(l int) float64 {
+	n := float64(s.Columns[l])
+	return (s.XbarYbar[l] - s.X[l]*s.Y[l]/n) / n
+}
+
+// GetP2 returns the probability of substitutions at both columns
+// of a pair at lag l.
+func (s *Stats) GetP2(l int) float64 {
+	return float64(s.XYCount[l]) / float64(s.NXY[l])
+}
+
+func upper(b byte) byte {
+	if b >= 'a' && b <= 'z' {
+		return b - 'a' + 'A'
+	}
+	return b
+}
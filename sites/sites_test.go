@@ -0,0 +1,221 @@
+package sites
+
+import (
+	"github.com/mingzhi/biogo/seq"
+	"github.com/mingzhi/simmlst/cov"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// naive calculates the statistics by pairs of sequences from every pair
+// of sites, and those by columns from their definitions.
+func naive(sequences [][]byte, policy cov.GapPolicy, maxl int, circular bool) (*Stats, naiveColumns) {
+	s := NewStats(maxl, circular)
+	L, n := len(sequences[0]), len(sequences)
+	columns := policy.Columns(sequences)
+	valid := func(i, k int) bool { return columns[k] && policy.Valid(sequences[i][k]) }
+	diff := func(i, j, k int) bool { return upper(sequences[i][k]) != upper(sequences[j][k]) }
+	partner := func(k, l int) (int, bool) {
+		if circular {
+			return (k + l) % L, true
+		}
+		return k + l, k+l < L
+	}
+
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			var sites, subs int
+			for k := 0; k < L; k++ {
+				if valid(i, k) && valid(j, k) {
+					sites++
+					if diff(i, j, k) {
+						subs++
+					}
+				}
+			}
+			if sites == 0 {
+				continue
+			}
+			d := float64(subs) / float64(sites)
+			s.Pairs++
+			s.D += d
+			s.D2 += d * d
+			s.Subs += int64(subs)
+			s.Sites += int64(sites)
+			for l := 0; l < maxl; l++ {
+				var pairs, xy int
+				for k := 0; k < L; k++ {
+					h, ok := partner(k, l)
+					if ok && valid(i, k) && valid(j, k) && valid(i, h) && valid(j, h) {
+						pairs++
+						if diff(i, j, k) && diff(i, j, h) {
+							xy++
+						}
+					}
+				}
+				if pairs > 0 {
+					s.Cm[l] += float64(xy)/float64(pairs) - d*d
+					s.CmN[l]++
+					s.XY[l] += int64(xy)
+					s.SitePairs[l] += int64(pairs)
+				}
+			}
+		}
+	}
+
+	// the substitution rates x and y of the pairs of sequences
+	// at both columns of every pair of columns with valid pairs.
+	var c naiveColumns
+	for l := 0; l < maxl; l++ {
+		var xs, ys, covs []float64
+		var both, compared int
+		for k := 0; k < L; k++ {
+			h, ok := partner(k, l)
+			if !ok {
+				continue
+			}
+			var pairs, nx, ny, nxy int
+			for i := 0; i < n; i++ {
+				for j := i + 1; j < n; j++ {
+					if valid(i, k) && valid(j, k) && valid(i, h) && valid(j, h) {
+						pairs++
+						x, y := diff(i, j, k), diff(i, j, h)
+						if x {
+							nx++
+						}
+						if y {
+							ny++
+						}
+						if x && y {
+							nxy++
+						}
+					}
+				}
+			}
+			if pairs == 0 {
+				continue
+			}
+			x, y := float64(nx)/float64(pairs), float64(ny)/float64(pairs)
+			xs, ys = append(xs, x), append(ys, y)
+			covs = append(covs, float64(nxy)/float64(pairs)-x*y)
+			both += nxy
+			compared += pairs
+		}
+		c.Cs = append(c.Cs, mean(covs))
+		var xy []float64
+		for k := range xs {
+			xy = append(xy, xs[k]*ys[k])
+		}
+		c.Cr = append(c.Cr, mean(xy)-mean(xs)*mean(ys))
+		c.P2 = append(c.P2, float64(both)/float64(compared))
+	}
+
+	return s, c
+}
+
+// naiveColumns are the statistics by columns at every lag.
+type naiveColumns struct {
+	Cs, Cr, P2 []float64
+}
+
+func mean(v []float64) float64 {
+	var sum float64
+	for _, x := range v {
+		sum += x
+	}
+	return sum / float64(len(v))
+}
+
+func randomAlignment(n, length int, mutation, gap float64, r *rand.Rand) [][]byte {
+	var sequences [][]byte
+	ancestor := make([]byte, length)
+	for k := range ancestor {
+		ancestor[k] = "ACGT"[r.Intn(4)]
+	}
+	for i := 0; i < n; i++ {
+		s := make([]byte, length)
+		copy(s, ancestor)
+		for k := range s {
+			switch x := r.Float64(); {
+			case x < gap:
+				s[k] = "-Nn"[r.Intn(3)]
+			case x < gap+mutation:
+				s[k] = "ACGTacgt"[r.Intn(8)]
+			}
+		}
+		sequences = append(sequences, s)
+	}
+	return sequences
+}
+
+func TestCalc(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	maxl := 40
+	for _, gap := range []float64{0, 0.03} {
+		sequences := randomAlignment(5, 120, 0.05, gap, r)
+		for _, policy := range []cov.GapPolicy{cov.SkipGaps, cov.GapState, cov.DropGapColumns} {
+			for _, circular := range []bool{false, true} {
+				got := Calc(NewTable(sequences, policy), maxl, circular)
+				expected, columns := naive(sequences, policy, maxl, circular)
+				if got.Pairs != expected.Pairs || got.Subs != expected.Subs || got.Sites != expected.Sites {
+					t.Errorf("%v, circular %v: Expect %d pairs, %d subs, %d sites, got %d, %d, %d", policy, circular,
+						expected.Pairs, expected.Subs, expected.Sites, got.Pairs, got.Subs, got.Sites)
+				}
+				compare(t, "Ks", expected.GetKs(), got.GetKs())
+				compare(t, "Vd", expected.GetVd(), got.GetVd())
+				for l := 0; l < maxl; l++ {
+					compare(t, "Cm", expected.GetCm(l), got.GetCm(l))
+					compare(t, "Ct", expected.GetCt(l), got.GetCt(l))
+					compare(t, "Cs", columns.Cs[l], got.GetCs(l))
+					compare(t, "Cr", columns.Cr[l], got.GetCr(l))
+					compare(t, "P2", columns.P2[l], got.GetP2(l))
+				}
+			}
+		}
+	}
+}
+
+func TestCmShortAlignments(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	maxl := 40
+	long := randomAlignment(5, 120, 0.05, 0, r)
+	short := randomAlignment(5, 20, 0.05, 0, r)
+	got := NewStats(maxl, false)
+	got.Add(NewTable(long, cov.SkipGaps))
+	got.Add(NewTable(short, cov.SkipGaps))
+
+	// pairs of the short alignment have no lag covariance beyond it.
+	a, _ := naive(long, cov.SkipGaps, maxl, false)
+	b, _ := naive(short, cov.SkipGaps, maxl, false)
+	for l := 0; l < maxl; l++ {
+		expected := a.Cm[l] / float64(a.Pairs)
+		if l < len(short[0]) {
+			expected = (a.Cm[l] + b.Cm[l]) / float64(a.Pairs+b.Pairs)
+		}
+		compare(t, "Cm", expected, got.GetCm(l))
+	}
+}
+
+func TestCalcMatchesCov(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	sequences := randomAlignment(6, 500, 0.05, 0.01, r)
+	var genes []*seq.Sequence
+	for _, s := range sequences {
+		genes = append(genes, seq.NewSequence("", s))
+	}
+
+	maxl := 100
+	stats := Calc(NewTable(sequences, cov.SkipGaps), maxl, false)
+	ct := cov.CalcCtBits(genes, maxl)
+	compare(t, "Ks", ct.GetKs(), stats.GetPooledKs())
+	for l := 0; l < maxl; l++ {
+		compare(t, "Ct", ct.GetPooledResult(l), stats.GetCt(l))
+	}
+}
+
+func compare(t *testing.T, name string, expected, got float64) {
+	if math.Abs(expected-got) > 1e-9*math.Max(1, math.Abs(expected)) {
+		t.Errorf("Expect %s %g, got %g", name, expected, got)
+	}
+}
@@ -0,0 +1,159 @@
+// Package theory gives the expected correlation statistics of alignments
+// under the coalescent with gene conversion (Wiuf and Hein 2000),
+// to check them against simulations.
+//
+// Times are scaled so that a pair of sequences coalesces at rate 1:
+// the coalescence time T of a pair at a site has mean and variance 1.
+// Mutations occur at rate Theta/2 per site and lineage, under the
+// Jukes-Cantor model, and gene conversion tracts start at rate Rho/2
+// per site and lineage, with geometric lengths of mean Delta.
+// Two sites at lag l are then separated at the scaled rate
+//
+//	R(l) = 2 Rho Delta (1 - (1 - 1/Delta)^l),
+//
+// and their coalescence times have the covariance of two loci
+// separated by recombination at rate R (Hudson 1983),
+//
+//	c(l) = (R+18) / (R^2+13R+18).
+//
+// The differences of a pair at two sites have the covariance Ks^2 c(l),
+// to second order in Theta. Sites of different blocks are at an infinite
+// lag, where R is 2 Rho Delta, since gene conversion never unlinks them.
+// The (co)variances of the means over the pairs of N sequences are those
+// of a pair, scaled by the ratios of Tajima (1983): (N+1)/(3(N-1))
+// at a site, and 2(N^2+N+3)/(9N(N-1)) between sites.
+//
+// Expectations ignore tracts truncated at the ends of blocks, and
+// SimMLST draws donors from the clonal genealogy, which departs from
+// the coalescent with gene conversion when Rho Delta is large.
+package theory
+
+import (
+	"errors"
+	"math"
+)
+
+// Params stores the parameters of the model.
+type Params struct {
+	N          int     // number of sequences.
+	Theta, Rho float64 // scaled mutation and gene conversion rates per site.
+	Delta      float64 // mean length of tracts.
+	Blocks     []int   // lengths of the blocks.
+}
+
+// Validate checks the parameters.
+func (p Params) Validate() error {
+	if p.N < 2 {
+		return errors.New("theory: N must be at least 2")
+	}
+	if !(p.Theta >= 0) || !(p.Rho >= 0) || math.IsInf(p.Theta, 0) || math.IsInf(p.Rho, 0) {
+		return errors.New("theory: Theta and Rho must be finite and not negative")
+	}
+	if !(p.Delta > 0) || math.IsInf(p.Delta, 0) {
+		return errors.New("theory: Delta must be finite and positive")
+	}
+	if len(p.Blocks) == 0 {
+		return errors.New("theory: no blocks")
+	}
+	for _, l := range p.Blocks {
+		if l <= 0 {
+			return errors.New("theory: block lengths must be positive")
+		}
+	}
+	return nil
+}
+
+// Separation returns the scaled rate R(l) at which gene conversion
+// separates two sites at lag l; tracts of mean Delta below 1 have length 1.
+func Separation(rho, delta, l float64) float64 {
+	if delta < 1 {
+		delta = 1
+	}
+	return 2 * rho * delta * (1 - math.Pow(1-1/delta, l))
+}
+
+// Hudson returns the covariance of the coalescence times of a pair
+// at two loci separated at the scaled rate r.
+func Hudson(r float64) float64 {
+	return (r + 18) / (r*r + 13*r + 18)
+}
+
+// Ks returns the expected substitution rate of a pair of sequences.
+func (p Params) Ks() float64 {
+	return p.Theta / (1 + 4*p.Theta/3)
+}
+
+// Cov returns the covariance of the differences of a pair
+// at two sites at lag l.
+func (p Params) Cov(l int) float64 {
+	ks := p.Ks()
+	if l == 0 {
+		return ks * (1 - ks)
+	}
+	return ks * ks * Hudson(Separation(p.Rho, p.Delta, float64(l)))
+}
+
+// Expectations are the expected statistics of an alignment,
+// as calculated by sites.Stats.
+type Expectations struct {
+	Ks    float64 // substitution rate of pairs of sequences.
+	KsVar float64 // variance of the pooled Ks of alignments.
+	Vd    float64 // variance of the substitution rates of pairs.
+	// lag covariances of pairs, and pooled over pairs,
+	// at lags shorter than every block.
+	Cm, Ct []float64
+}
+
+// Expect returns the expected statistics at lags below maxl.
+func (p Params) Expect(maxl int) (Expectations, error) {
+	if err := p.Validate(); err != nil {
+		return Expectations{}, err
+	}
+
+	longest := maxl
+	for _, l := range p.Blocks {
+		if l > longest {
+			longest = l
+		}
+	}
+	cov := make([]float64, longest)
+	for l := range cov {
+		cov[l] = p.Cov(l)
+	}
+	ks := p.Ks()
+	far := ks * ks * Hudson(2*p.Rho*math.Max(p.Delta, 1))
+
+	n := float64(p.N)
+	site := (n + 1) / (3 * (n - 1))
+	between := 2 * (n*n + n + 3) / (9 * n * (n - 1))
+
+	// variances of the rate of a pair in a block, and of the mean of the
+	// pairs, sum the covariances of the pairs of sites of the block.
+	var pair, sites, squares float64
+	var means, weighted float64
+	for _, l := range p.Blocks {
+		L := float64(l)
+		var linked float64
+		for lag := 1; lag < l; lag++ {
+			linked += 2 * (L - float64(lag)) * cov[lag]
+		}
+		pair += (L*cov[0] + linked) / (L * L)
+		mean := (site*L*cov[0] + between*linked) / (L * L)
+		means += mean
+		weighted += L * L * mean
+		sites += L
+		squares += L * L
+	}
+	blocks := float64(len(p.Blocks))
+	pair /= blocks
+	// Vd and Cm weigh blocks equally, and Ct and the pooled Ks by sites.
+	perBlock := (means + blocks*(blocks-1)*between*far) / (blocks * blocks)
+	pooled := (weighted + (sites*sites-squares)*between*far) / (sites * sites)
+
+	e := Expectations{Ks: ks, KsVar: pooled, Vd: pair - perBlock}
+	for l := 0; l < maxl; l++ {
+		e.Cm = append(e.Cm, cov[l]-pair)
+		e.Ct = append(e.Ct, cov[l]-pooled)
+	}
+	return e, nil
+}
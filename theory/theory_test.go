@@ -0,0 +1,85 @@
+package theory
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSeparation(t *testing.T) {
+	if r := Separation(0.1, 20, 0); r != 0 {
+		t.Errorf("Expect no separation at lag 0, got %g", r)
+	}
+	if r := Separation(0.1, 20, 1e6); math.Abs(r-4) > 1e-9 {
+		t.Errorf("Expect separation 2 rho delta = 4 at long lags, got %g", r)
+	}
+	if r := Separation(0.1, 1, 3); math.Abs(r-0.2) > 1e-12 {
+		t.Errorf("Expect separation 2 rho = 0.2 of tracts of length 1, got %g", r)
+	}
+	if c := Hudson(0); c != 1 {
+		t.Errorf("Expect covariance 1 of linked sites, got %g", c)
+	}
+}
+
+func TestExpectLinked(t *testing.T) {
+	// without gene conversion, the differences of a pair at two sites
+	// have the second moment 2 Ks^2, and its rate the second moment
+	// (L Ks + L(L-1) 2 Ks^2) / L^2.
+	p := Params{N: 2, Theta: 0.01, Rho: 0, Delta: 10, Blocks: []int{50}}
+	e, err := p.Expect(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := 0.01 / (1 + 0.04/3)
+	if math.Abs(e.Ks-ks) > 1e-15 {
+		t.Errorf("Expect Ks %g, got %g", ks, e.Ks)
+	}
+	d2 := (50*ks + 50*49*2*ks*ks) / (50 * 50)
+	for l := 1; l < 10; l++ {
+		if math.Abs(e.Cm[l]-(2*ks*ks-d2)) > 1e-15 {
+			t.Errorf("Expect Cm %g at lag %d, got %g", 2*ks*ks-d2, l, e.Cm[l])
+		}
+	}
+	if math.Abs(e.Cm[0]-(ks-d2)) > 1e-15 {
+		t.Errorf("Expect Cm %g at lag 0, got %g", ks-d2, e.Cm[0])
+	}
+	// a single pair of a single block has no variance between pairs,
+	// and pools to its own rate.
+	if math.Abs(e.Vd) > 1e-15 || math.Abs(e.KsVar-(d2-ks*ks)) > 1e-15 {
+		t.Errorf("Expect Vd 0 and KsVar %g, got %g and %g", d2-ks*ks, e.Vd, e.KsVar)
+	}
+	for l := range e.Ct {
+		if math.Abs(e.Ct[l]-e.Cm[l]) > 1e-15 {
+			t.Errorf("Expect Ct as Cm of a single pair, got %g and %g", e.Ct[l], e.Cm[l])
+		}
+	}
+}
+
+func TestExpect(t *testing.T) {
+	p := Params{N: 20, Theta: 0.01, Rho: 0.01, Delta: 100, Blocks: []int{400, 400, 400}}
+	e, err := p.Expect(200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for l := 2; l < 200; l++ {
+		if !(e.Ct[l] < e.Ct[l-1]) {
+			t.Errorf("Expect Ct to decay with lags, got %g after %g at lag %d", e.Ct[l], e.Ct[l-1], l)
+		}
+	}
+	if !(e.Vd > 0) || !(e.KsVar > 0) {
+		t.Errorf("Expect positive Vd and KsVar, got %g and %g", e.Vd, e.KsVar)
+	}
+
+	// independent sites have no covariance, only that of the pooled Ks.
+	p.Rho, p.Delta = 1e9, 1
+	e, err = p.Expect(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(e.Ct[5]+e.KsVar) > 1e-12 || e.KsVar > e.Ks/1200 {
+		t.Errorf("Expect Ct -KsVar of independent sites, got %g and KsVar %g", e.Ct[5], e.KsVar)
+	}
+
+	if _, err := (Params{N: 1, Blocks: []int{10}}).Expect(5); err == nil {
+		t.Errorf("Expect an error of a single sequence")
+	}
+}
@@ -0,0 +1,124 @@
+//go:build validation
+// +build validation
+
+package validate
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/mingzhi/simmlst"
+	"os"
+	"runtime"
+	"testing"
+)
+
+// The battery runs with go test -tags validation, and its flags
+// follow -args.
+var (
+	backend    = flag.String("validate.backend", "native", "simulator backend")
+	program    = flag.String("validate.program", "", "path of the program of an external backend")
+	replicates = flag.Int("validate.replicates", 100, "replicates of every case")
+	pool       = flag.Int("validate.pool", 40, "simulations pooled in every replicate")
+	seed       = flag.Int64("validate.seed", 1, "master seed")
+	alpha      = flag.Float64("validate.alpha", 0.01, "family-wise error rate")
+	tolerance  = flag.Float64("validate.tolerance", 0.25, "relative bias accepted")
+	maxl       = flag.Int("validate.maxl", 100, "maximum lag")
+	models     = flag.String("validate.models", "", "JSON file of the fits, as simmlst_fit -models (default those of simmlst_fit)")
+	report     = flag.String("validate.report", "validation_report", "prefix of the .txt and .json reports")
+)
+
+// cases crosses recombination rates and tract lengths,
+// of 10 isolates and a gene of 10000 sites.
+//
+// The default fits are known to fail all but rho/theta of the case of
+// least recombination: rho delta, from 1.25 to 20 per site here, is large
+// enough that SimMLST departs from the coalescent with gene conversion
+// that the fit of Coalescent assumes (see package theory), and Ct
+// flattens, so that rho/theta falls short by 15% to 80% as rho delta
+// grows, and delta is overestimated, without bound at delta 200.
+func cases() []Case {
+	var cs []Case
+	for _, rho := range []float64{250, 1000} {
+		for _, delta := range []int{50, 200} {
+			ps := simmlst.Config{Theta: 1000, Rho: rho, N: 10, Delta: delta, NumGene: 1, LenGene: 10000}
+			known := []string{"rho/theta", "delta"}
+			if rho == 250 && delta == 50 {
+				known = []string{"delta"}
+			}
+			cs = append(cs, Case{Name: fmt.Sprintf("rho=%g,delta=%d", rho, delta), Config: ps, Known: known})
+		}
+	}
+	return cs
+}
+
+func TestBattery(t *testing.T) {
+	simulator, err := simmlst.NewSimulator(*backend, *program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fits []Fit
+	if *models == "" {
+		fits = DefaultFits
+	} else {
+		f, err := os.Open(*models)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = json.NewDecoder(f).Decode(&fits)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	estimator, err := FitEstimator(*maxl, fits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := Battery{
+		Cases:      cases(),
+		Replicates: *replicates,
+		Pool:       *pool,
+		Seed:       *seed,
+		Simulator:  simulator,
+		Estimator:  estimator,
+		Workers:    runtime.NumCPU(),
+		Alpha:      *alpha,
+		Tolerance:  *tolerance,
+	}
+	r, err := b.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Create(*report + ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := r.WriteText(f); err != nil {
+		t.Fatal(err)
+	}
+	w, err := os.Create(*report + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := json.NewEncoder(w).Encode(r); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range r.Tests {
+		switch {
+		case test.Pass && test.Known:
+			t.Logf("%s of %s, a known failure, passed", test.Parameter, test.Case)
+		case test.Known:
+			t.Logf("Known failure: %s of %s is %g ± %g, not within %g%% of %g",
+				test.Parameter, test.Case, test.Mean, test.SE, 100*r.Tolerance, test.Truth)
+		case !test.Pass:
+			t.Errorf("Expect %s of %s within %g%% of %g, got %g ± %g (adjusted p-value %.2g)",
+				test.Parameter, test.Case, 100*r.Tolerance, test.Truth, test.Mean, test.SE, test.Adjusted)
+		}
+	}
+}
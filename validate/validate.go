@@ -0,0 +1,328 @@
+// Package validate checks that the estimates of rho/theta and delta
+// recover the parameters of simulations, over batteries of cases.
+//
+// Every case of a battery is estimated a number of times, each from
+// simulations pooled as simmlst_calc pools its replicates, and the mean
+// of its estimates is tested against the truth: it fails if it departs
+// from it by more than a relative tolerance, the bias accepted of the
+// method, beyond its sampling error. The p-values of all the tests of a
+// battery are adjusted by the method of Holm (1979), so that a method
+// within the tolerance fails a battery with probability at most Alpha,
+// whatever the number of cases.
+package validate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mingzhi/biogo/seq"
+	"github.com/mingzhi/simmlst"
+	"github.com/mingzhi/simmlst/cmd"
+	"github.com/mingzhi/simmlst/cov"
+	"github.com/mingzhi/simmlst/sites"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"text/tabwriter"
+)
+
+// Case is a named parameter set to simulate. Known lists the parameters,
+// rho/theta or delta, whose tests are known to fail: they are reported,
+// but do not fail the battery.
+type Case struct {
+	Name   string
+	Config simmlst.Config
+	Known  []string
+}
+
+// Fit is a model fitted to the lags from Start to End exclusive
+// (End < 0 for all lags), as in simmlst_fit.
+type Fit struct {
+	Model      string
+	Start, End int
+}
+
+// DefaultFits are the fits of simmlst_fit by default.
+var DefaultFits = []Fit{
+	{Model: "Coalescent", Start: 1, End: -1},
+	{Model: "Hyper", Start: 1, End: 10},
+}
+
+// Estimator estimates the parameters of an alignment,
+// or of simulations pooled as the blocks of one.
+type Estimator func(geneGroups [][]*seq.Sequence) cmd.Estimates
+
+// FitEstimator returns the estimator of the fits of models
+// to Ct at lags below maxl, as simmlst_calc and simmlst_fit.
+func FitEstimator(maxl int, fits []Fit) (Estimator, error) {
+	var models []cmd.Model
+	for _, f := range fits {
+		m, err := cmd.LookupModel(f.Model)
+		if err != nil {
+			return nil, err
+		}
+		if f.Start < 0 || (f.End >= 0 && f.End <= f.Start) {
+			return nil, fmt.Errorf("lags from %d to %d of model %s", f.Start, f.End, f.Model)
+		}
+		models = append(models, m)
+	}
+
+	return func(geneGroups [][]*seq.Sequence) cmd.Estimates {
+		s := sites.NewStats(maxl, false)
+		for _, genes := range geneGroups {
+			var sequences [][]byte
+			for _, g := range genes {
+				sequences = append(sequences, g.Seq)
+			}
+			s.Add(sites.NewTable(sequences, cov.SkipGaps))
+		}
+		c := cmd.NewCovResult(s, maxl)
+
+		var results []cmd.FitResult
+		for i, f := range fits {
+			end := f.End
+			if end < 0 || end > len(c.Ct) {
+				end = len(c.Ct)
+			}
+			results = append(results, models[i].Fit(c, f.Start, end))
+		}
+		cmd.Compare(results)
+		return cmd.Estimate(results)
+	}, nil
+}
+
+// Battery is a set of cases, each estimated Replicates times from Pool
+// simulations, 1 if not set, with the seeds derived from Seed
+// (see simmlst.DeriveSeed). Replicates run on parallel workers,
+// but reports depend only on Seed.
+type Battery struct {
+	Cases      []Case
+	Replicates int
+	Pool       int
+	Seed       int64
+	Simulator  simmlst.Simulator
+	Estimator  Estimator
+	Workers    int
+	Alpha      float64 // family-wise error rate of the battery.
+	Tolerance  float64 // relative bias accepted of the estimates.
+}
+
+// Validate checks the battery.
+func (b Battery) Validate() error {
+	if len(b.Cases) == 0 {
+		return errors.New("validate: no cases")
+	}
+	for _, c := range b.Cases {
+		if !(c.Config.Theta > 0) || !(c.Config.Rho > 0) {
+			return fmt.Errorf("validate: case %s: Theta and Rho must be positive", c.Name)
+		}
+		if err := c.Config.Validate(); err != nil {
+			return fmt.Errorf("validate: case %s: %v", c.Name, err)
+		}
+	}
+	if b.Replicates < 2 {
+		return fmt.Errorf("validate: %d replicates, expect at least 2", b.Replicates)
+	}
+	if b.Simulator == nil || b.Estimator == nil {
+		return errors.New("validate: no simulator or estimator")
+	}
+	if !(b.Alpha > 0 && b.Alpha < 1) {
+		return fmt.Errorf("validate: alpha %g, expect within (0, 1)", b.Alpha)
+	}
+	if !(b.Tolerance >= 0) {
+		return fmt.Errorf("validate: tolerance %g, expect not negative", b.Tolerance)
+	}
+	return nil
+}
+
+// Test is the test of the estimates of a parameter of a case.
+type Test struct {
+	Case      string
+	Parameter string // rho/theta or delta.
+	Truth     float64
+	Mean, SE  float64 // of the estimates, 0 with fewer than two.
+	Bias      float64 // of the mean, relative to the truth.
+	Estimates int     // replicates with an estimate.
+	Undefined int     // replicates without one.
+	Failed    int     // replicates of a failed simulation.
+	// Z is the departure of the mean beyond the tolerance, in standard
+	// errors, 0 within it. P is its two-sided p-value, and Adjusted
+	// the p-value adjusted for the tests of the battery.
+	Z, P, Adjusted float64
+	Pass           bool
+	Known          bool // a known failure of the case.
+}
+
+// Report is the outcome of a battery.
+type Report struct {
+	Replicates, Pool int
+	Seed             int64
+	Alpha, Tolerance float64
+	Tests            []Test
+	Failures         int // failed tests, but known failures.
+	KnownFailures    int
+}
+
+// Run runs the battery.
+func (b Battery) Run(ctx context.Context) (Report, error) {
+	if err := b.Validate(); err != nil {
+		return Report{}, err
+	}
+
+	type outcome struct {
+		e   cmd.Estimates
+		err error
+	}
+	outcomes := make([]outcome, len(b.Cases)*b.Replicates)
+	workers := b.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	pool := b.Pool
+	if pool < 1 {
+		pool = 1
+	}
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				c, r := i/b.Replicates, i%b.Replicates
+				ps := b.Cases[c].Config
+				var pooled [][]*seq.Sequence
+				for k := 0; k < pool && outcomes[i].err == nil; k++ {
+					ps.Seed = simmlst.DeriveSeed(b.Seed, c, r*pool+k)
+					geneGroups, err := b.Simulator.Simulate(ctx, ps)
+					outcomes[i].err = err
+					pooled = append(pooled, geneGroups...)
+				}
+				if outcomes[i].err == nil {
+					outcomes[i].e = b.Estimator(pooled)
+				}
+			}
+		}()
+	}
+	for i := range outcomes {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return Report{}, err
+	}
+
+	r := Report{Replicates: b.Replicates, Pool: pool, Seed: b.Seed, Alpha: b.Alpha, Tolerance: b.Tolerance}
+	for c, cs := range b.Cases {
+		rhoTheta := Test{Case: cs.Name, Parameter: "rho/theta", Truth: cs.Config.Rho / cs.Config.Theta}
+		delta := Test{Case: cs.Name, Parameter: "delta", Truth: float64(cs.Config.Delta)}
+		var rts, deltas []float64
+		for _, o := range outcomes[c*b.Replicates : (c+1)*b.Replicates] {
+			if o.err != nil {
+				rhoTheta.Failed++
+				delta.Failed++
+				continue
+			}
+			rts = collect(rts, o.e.RhoTheta, &rhoTheta)
+			deltas = collect(deltas, o.e.Delta, &delta)
+		}
+		for _, k := range cs.Known {
+			rhoTheta.Known = rhoTheta.Known || k == rhoTheta.Parameter
+			delta.Known = delta.Known || k == delta.Parameter
+		}
+		rhoTheta.test(rts, b.Tolerance)
+		delta.test(deltas, b.Tolerance)
+		r.Tests = append(r.Tests, rhoTheta, delta)
+	}
+
+	var ps []float64
+	for _, t := range r.Tests {
+		ps = append(ps, t.P)
+	}
+	for i, p := range Holm(ps) {
+		r.Tests[i].Adjusted = p
+		r.Tests[i].Pass = p > b.Alpha
+		switch {
+		case r.Tests[i].Pass:
+		case r.Tests[i].Known:
+			r.KnownFailures++
+		default:
+			r.Failures++
+		}
+	}
+	return r, nil
+}
+
+// collect appends a defined estimate, or counts an undefined one.
+func collect(values []float64, v *float64, t *Test) []float64 {
+	if v == nil {
+		t.Undefined++
+		return values
+	}
+	t.Estimates++
+	return append(values, *v)
+}
+
+// test tests the mean of estimates against the truth; fewer than two
+// estimates, or a departure without sampling error, have a p-value of 0.
+func (t *Test) test(values []float64, tolerance float64) {
+	if len(values) < 2 {
+		return
+	}
+	n := float64(len(values))
+	for _, v := range values {
+		t.Mean += v / n
+	}
+	var ss float64
+	for _, v := range values {
+		ss += (v - t.Mean) * (v - t.Mean)
+	}
+	t.SE = math.Sqrt(ss / (n - 1) / n)
+	t.Bias = (t.Mean - t.Truth) / t.Truth
+
+	excess := math.Abs(t.Mean-t.Truth) - tolerance*t.Truth
+	switch {
+	case excess <= 0:
+		t.P = 1
+	case t.SE > 0:
+		t.Z = excess / t.SE
+		t.P = math.Erfc(t.Z / math.Sqrt2)
+	}
+}
+
+// Holm returns the p-values adjusted for multiple testing by the method
+// of Holm (1979): the k-th smallest of m p-values is multiplied by m-k+1,
+// and kept no smaller than the adjusted p-values of those before it.
+func Holm(p []float64) []float64 {
+	m := len(p)
+	order := make([]int, m)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return p[order[a]] < p[order[b]] })
+
+	adjusted := make([]float64, m)
+	max := 0.0
+	for k, i := range order {
+		v := math.Min(1, float64(m-k)*p[i])
+		max = math.Max(max, v)
+		adjusted[i] = max
+	}
+	return adjusted
+}
+
+// WriteText writes the report as a table.
+func (r Report) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "%d replicates of %d pooled simulations, seed %d, alpha %g, tolerance %g: %d of %d tests failed, and %d known failures\n\n",
+		r.Replicates, r.Pool, r.Seed, r.Alpha, r.Tolerance, r.Failures, len(r.Tests), r.KnownFailures)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "case\tparameter\ttruth\tmean\tse\tbias\testimates\tundefined\tfailed\tz\tp\tadjusted\tpass\tknown")
+	for _, t := range r.Tests {
+		fmt.Fprintf(tw, "%s\t%s\t%g\t%.4g\t%.2g\t%+.1f%%\t%d\t%d\t%d\t%.2f\t%.2g\t%.2g\t%v\t%v\n",
+			t.Case, t.Parameter, t.Truth, t.Mean, t.SE, 100*t.Bias,
+			t.Estimates, t.Undefined, t.Failed, t.Z, t.P, t.Adjusted, t.Pass, t.Known)
+	}
+	return tw.Flush()
+}
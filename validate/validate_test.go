@@ -0,0 +1,161 @@
+package validate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/mingzhi/biogo/seq"
+	"github.com/mingzhi/simmlst"
+	"github.com/mingzhi/simmlst/cmd"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fake simulates no alignment: it records the parameters of a config
+// in the id of a sequence, biased and perturbed by noise of its seed,
+// for estimate to read back. Every seventh seed fails.
+type fake struct {
+	bias float64
+}
+
+func (f fake) Simulate(ctx context.Context, ps simmlst.Config) ([][]*seq.Sequence, error) {
+	if ps.Seed%7 == 0 {
+		return nil, errors.New("fake: failed")
+	}
+	r := rand.New(rand.NewSource(ps.Seed))
+	rt := ps.Rho / ps.Theta * (1 + f.bias + 0.1*r.NormFloat64())
+	delta := float64(ps.Delta) * (1 + f.bias + 0.1*r.NormFloat64())
+	id := fmt.Sprintf("%g %g", rt, delta)
+	return [][]*seq.Sequence{{seq.NewSequence(id, nil)}}, nil
+}
+
+func estimate(geneGroups [][]*seq.Sequence) cmd.Estimates {
+	var rt, delta float64
+	fmt.Sscan(geneGroups[0][0].Id, &rt, &delta)
+	return cmd.Estimates{RhoTheta: &rt, Delta: &delta}
+}
+
+func newBattery(bias float64, workers int) Battery {
+	ps := simmlst.Config{Theta: 100, Rho: 50, N: 10, Delta: 40, NumGene: 2, LenGene: 500}
+	ps2 := ps
+	ps2.Rho, ps2.Delta = 200, 100
+	return Battery{
+		Cases:      []Case{{Name: "low", Config: ps}, {Name: "high", Config: ps2}},
+		Replicates: 200,
+		Seed:       1,
+		Simulator:  fake{bias},
+		Estimator:  estimate,
+		Workers:    workers,
+		Alpha:      0.01,
+		Tolerance:  0.05,
+	}
+}
+
+func TestRun(t *testing.T) {
+	r, err := newBattery(0, 4).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Tests) != 4 || r.Failures != 0 {
+		t.Errorf("Expect 4 tests passed, got %d failures of %+v", r.Failures, r.Tests)
+	}
+	for _, test := range r.Tests {
+		if test.Failed == 0 || test.Estimates+test.Failed != 200 {
+			t.Errorf("Expect failed simulations among 200, got %d estimates and %d failed", test.Estimates, test.Failed)
+		}
+		if math.Abs(test.Bias) > 0.05 {
+			t.Errorf("Expect no bias of %s of %s, got %g", test.Parameter, test.Case, test.Bias)
+		}
+	}
+
+	// reports do not depend on the number of workers.
+	r1, err := newBattery(0, 1).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r, r1) {
+		t.Errorf("Expect the same reports of 1 and 4 workers")
+	}
+
+	r, err = newBattery(0.2, 4).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Failures != 4 {
+		t.Errorf("Expect 4 failed tests of a bias of 20%%, got %d", r.Failures)
+	}
+	var b bytes.Buffer
+	if err := r.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), "4 of 4 tests failed") {
+		t.Errorf("Expect a report of 4 failed tests, got %q", b.String())
+	}
+
+	// known failures are reported apart.
+	biased := newBattery(0.2, 4)
+	biased.Cases[1].Known = []string{"delta"}
+	r, err = biased.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Failures != 3 || r.KnownFailures != 1 || !r.Tests[3].Known || r.Tests[2].Known {
+		t.Errorf("Expect 3 failed tests and a known failure of delta of high, got %d and %d", r.Failures, r.KnownFailures)
+	}
+}
+
+func TestPool(t *testing.T) {
+	b := newBattery(0, 4)
+	b.Pool = 3
+	b.Estimator = func(geneGroups [][]*seq.Sequence) cmd.Estimates {
+		n := float64(len(geneGroups))
+		return cmd.Estimates{RhoTheta: &n, Delta: &n}
+	}
+	r, err := b.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Pool != 3 {
+		t.Errorf("Expect a report of 3 pooled simulations, got %d", r.Pool)
+	}
+	for _, test := range r.Tests {
+		if math.Abs(test.Mean-3) > 1e-9 || test.SE > 1e-9 {
+			t.Errorf("Expect the blocks of 3 simulations in every estimate, got %g ± %g", test.Mean, test.SE)
+		}
+	}
+}
+
+func TestHolm(t *testing.T) {
+	got := Holm([]float64{0.01, 0.04, 0.03, 0.005})
+	expected := []float64{0.03, 0.06, 0.06, 0.02}
+	for i := range expected {
+		if math.Abs(got[i]-expected[i]) > 1e-12 {
+			t.Errorf("Expect adjusted p-values %v, got %v", expected, got)
+			break
+		}
+	}
+}
+
+func TestFitEstimator(t *testing.T) {
+	estimator, err := FitEstimator(100, DefaultFits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := simmlst.Config{Theta: 100, Rho: 50, N: 10, Delta: 40, NumGene: 10, LenGene: 1000, Seed: 1}
+	geneGroups, err := simmlst.Native{}.Simulate(context.Background(), ps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := estimator(geneGroups)
+	if e.RhoTheta == nil || e.Delta == nil {
+		t.Errorf("Expect estimates of rho/theta and delta, got %v", e.Undefined)
+	}
+
+	if _, err := FitEstimator(100, []Fit{{Model: "Unknown"}}); err == nil {
+		t.Errorf("Expect an error of an unknown model")
+	}
+}